@@ -1,11 +1,18 @@
 package ipfs_core
 
 import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+
 	"github.com/ipfs/go-ipfs/commands"
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/mitchellh/go-homedir"
 	"github.com/op/go-logging"
 
 	"gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
 )
 
 var (
@@ -15,7 +22,7 @@ var (
 
 var log_core = logging.MustGetLogger("core")
 
-var Node *SaturnNode
+var errNodeNotStarted = errors.New("Saturn node is not started")
 
 type SaturnNode struct {
 	// Context for issuing IPFS commands
@@ -43,4 +50,52 @@ type SaturnNode struct {
 
 	// Last ditch API to find records that dropped out of the DHT
 	IPNSBackupAPI string
+
+	// Options the node was created with
+	opts Options
+
+	// Context the IpfsNode was built with. It lives until Stop is called.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Guards the shutdown state below
+	lock sync.Mutex
+
+	// The new identity key after RotateIdentity, which root changes are
+	// published under until the node is restarted. Guarded by lock.
+	rotatedKey libp2p.PrivKey
+
+	// Replication state and wake-up channel of each push node
+	pushLock    sync.Mutex
+	pushStatus  map[peer.ID]*PushStatus
+	pushTrigger []chan struct{}
+
+	// Functions run by Stop before the IpfsNode is closed
+	shutdownHooks []func() error
+
+	// Closed once Stop has finished
+	done    chan struct{}
+	started bool
+	stopped bool
+}
+
+// NewSaturnNode returns a node which is neither initialized nor started.
+// Nodes share no state, so several of them may run in the same process as
+// long as they use different repo paths and ports.
+func NewSaturnNode(opts Options) (*SaturnNode, error) {
+	if opts.RepoPath == "" {
+		return nil, errors.New("Repo path must not be empty")
+	}
+	repoPath, err := homedir.Expand(opts.RepoPath)
+	if err != nil {
+		return nil, err
+	}
+	opts.RepoPath = filepath.Clean(repoPath)
+
+	return &SaturnNode{
+		RepoPath:  opts.RepoPath,
+		UserAgent: USERAGENT,
+		opts:      opts,
+		done:      make(chan struct{}),
+	}, nil
 }
@@ -0,0 +1,96 @@
+package ipfs_core
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
+
+	pstore "gx/ipfs/QmPgDWmTmuzvP7QE5zwo1TmjbJme9pmZHNujB2453jkCTr/go-libp2p-peerstore"
+	dht "gx/ipfs/QmT7PnPxYkeKPCG8pAnucfcjrXc15Q7FgvFv7YC24EPrw8/go-libp2p-kad-dht"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+// Options of a node with a repo in a temporary directory, which only
+// listens on loopback and has no bootstrap peers
+func testOptions(t *testing.T, opts ...Option) []Option {
+	return append([]Option{
+		WithRepoPath(filepath.Join(t.TempDir(), "repo")),
+		WithKey(libp2p.Ed25519, 0),
+		WithSwarmAddrs("/ip4/127.0.0.1/tcp/0"),
+		WithBootstrap(),
+		WithMDNS(false, 0),
+	}, opts...)
+}
+
+// Initializes and starts a node, which is stopped when the test ends
+func startTestNode(t *testing.T, opts ...Option) *SaturnNode {
+	n, err := Init(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := n.Stop(ctx); err != nil && err != errNodeStopped {
+			t.Error(err)
+		}
+	})
+	return n
+}
+
+// Connects a to b and adds b to the DHT routing table of a, which
+// publishing needs
+func connectTestNodes(t *testing.T, a, b *SaturnNode) {
+	pi := pstore.PeerInfo{ID: b.IpfsNode.Identity, Addrs: b.IpfsNode.PeerHost.Addrs()}
+	if err := a.IpfsNode.PeerHost.Connect(context.Background(), pi); err != nil {
+		t.Fatal(err)
+	}
+	a.IpfsNode.Routing.(*dht.IpfsDHT).Update(context.Background(), b.IpfsNode.Identity)
+}
+
+func TestIndependentNodes(t *testing.T) {
+	if _, err := NewSaturnNode(Options{}); err == nil {
+		t.Error("Created a node without a repo path")
+	}
+
+	a := startTestNode(t, testOptions(t)...)
+	b := startTestNode(t, testOptions(t)...)
+	if a.IpfsNode.Identity == b.IpfsNode.Identity {
+		t.Fatal("Nodes share their identity")
+	}
+	if a.Root() != b.Root() {
+		t.Fatalf("New nodes have different empty roots %s and %s", a.Root(), b.Root())
+	}
+
+	connectTestNodes(t, a, b)
+	root := addData(t, a, "a", false)
+	if err := a.PublishRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	if a.Root() != "/ipfs/"+root || b.Root() == a.Root() {
+		t.Errorf("Publishing on one node changed the other: a=%s b=%s", a.Root(), b.Root())
+	}
+
+	// Stopping one node leaves the other running
+	if err := a.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-b.Done():
+		t.Fatal("Stopping a node stopped the other")
+	default:
+	}
+	hash := addData(t, b, "b", true)
+	if _, err := ipfs_cmds.Cat(context.Background(), b.Context, hash); err != nil {
+		t.Errorf("Node is unusable after stopping the other: %s", err)
+	}
+	if _, err := b.Status(); err != nil {
+		t.Error(err)
+	}
+}
@@ -3,10 +3,8 @@ package ipfs_core
 import (
 	"context"
 	"errors"
-	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/op/go-logging"
@@ -25,7 +23,7 @@ const (
 )
 
 var log_repo = logging.MustGetLogger("repo")
-var errRepoExists = errors.New("IPFS configuration file exists. Reinitializing would overwrite your keys. Use -f to force overwrite.") // error message
+var errRepoExists = errors.New("IPFS configuration file exists. Reinitializing would overwrite your keys. Use WithForceReinit to force overwrite.") // error message
 
 var defBootstrapAddrs = []string{
 	//"/ip4/107.170.133.32/tcp/4001/ipfs/QmUZRGLhcKXF1JyuaHgKm23LvqcoMYwtb9jmh8CkP4og3K", // Le Marché Serpette
@@ -88,11 +86,33 @@ func datastoreConfig() config.Datastore {
 	}
 }
 
-func initConfig(repoRoot string, nBitsForKeypair int) (*config.Config, error) {
+// newIdentity generates the identity described by opts
+func newIdentity(opts Options) (config.Identity, error) {
+	if opts.IdentityKey != nil {
+		return ipfs_cmds.IdentityFromKey(opts.IdentityKey)
+	}
+	if opts.Seed != nil {
+		return ipfs_cmds.IdentityFromSeed(opts.Seed)
+	}
+	nBitsForKeypair := opts.KeyBits
+	if nBitsForKeypair == 0 {
+		nBitsForKeypair = BitForKeyPair
+	}
+	return ipfs_cmds.IdentityConfig(opts.KeyType, nBitsForKeypair)
+}
 
-	identity, err := ipfs_cmds.IdentityConfig(nBitsForKeypair)
-	if err != nil {
-		return nil, err
+// initConfig builds the config of a new repo. A new identity is generated
+// unless one is given.
+func initConfig(opts Options, ident *config.Identity) (*config.Config, error) {
+	var identity config.Identity
+	if ident != nil {
+		identity = *ident
+	} else {
+		var err error
+		identity, err = newIdentity(opts)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	bootstrapPeers, err := config.ParseBootstrapPeers(defBootstrapAddrs)
@@ -142,6 +162,9 @@ func initConfig(repoRoot string, nBitsForKeypair int) (*config.Config, error) {
 		},
 	}
 
+	if _, err := opts.applyConfig(conf, true); err != nil {
+		return nil, err
+	}
 	return conf, nil
 }
 
@@ -168,7 +191,8 @@ func initializeIpnsKeyspace(repoRoot string) error {
 	return namesys.InitializeKeyspace(ctx, nd.DAG, nd.Namesys, nd.Pinning, nd.PrivateKey)
 }
 
-func doInit(repoRoot string, nBitsForKeypair int) error {
+func doInit(opts Options, ident *config.Identity) error {
+	repoRoot := opts.RepoPath
 
 	if fsrepo.IsInitialized(repoRoot) {
 		return errRepoExists
@@ -178,7 +202,7 @@ func doInit(repoRoot string, nBitsForKeypair int) error {
 		return err
 	}
 
-	conf, err := initConfig(repoRoot, nBitsForKeypair)
+	conf, err := initConfig(opts, ident)
 	if err != nil {
 		return err
 	}
@@ -188,7 +212,7 @@ func doInit(repoRoot string, nBitsForKeypair int) error {
 		return err
 	}
 
-	f, err := os.Create(filepath.Join(repoRoot, "repover"))
+	f, err := os.Create(repoVersionPath(repoRoot))
 	if err != nil {
 		return err
 	}
@@ -198,39 +222,69 @@ func doInit(repoRoot string, nBitsForKeypair int) error {
 	}
 	f.Close()
 
-	return initializeIpnsKeyspace(repoRoot)
+	if err := initializeIpnsKeyspace(repoRoot); err != nil {
+		return err
+	}
+	if len(opts.Passphrase) > 0 {
+		return ChangePassphrase(repoRoot, nil, opts.Passphrase)
+	}
+	return nil
 }
 
-func initializeRepo(dataDir string) error {
-	// Initialize the IPFS repo if it does not already exist
-	return doInit(dataDir, BitForKeyPair)
+// Init creates a node from opts and initializes its repo
+func Init(opts ...Option) (*SaturnNode, error) {
+	o, err := NewOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	n, err := NewSaturnNode(o)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.Init(); err != nil {
+		return nil, err
+	}
+	return n, nil
 }
 
-func Init() (repoPtah string, err error) {
+// Init creates the repo of the node at its repo path.
+// An existing repo is kept as is, unless the node was created with
+// WithForceReinit. The old repo is then moved to a timestamped backup
+// directory next to it before a new one is created.
+// The keys of the new repo are encrypted if the node has a passphrase.
+func (n *SaturnNode) Init() error {
 	//=========================================== Init ===========================================
-	// Set repo path
-	repoPath, err := getRepoPath()
-	if err != nil {
-		return "", err
-	}
-	fmt.Println(repoPath)
+	repoPath := n.RepoPath
 
-	err = initializeRepo(repoPath)
-	if err != nil && err != errRepoExists {
-		return "", err
+	err := doInit(n.opts, nil)
+	if err != errRepoExists {
+		return err
 	}
-	if err == errRepoExists {
-		//reader := bufio.NewReader(os.Stdin)
-		fmt.Print("Force overwriting the db will destroy your existing keys and history. Are you really, really sure you want to continue? (y/n): ")
-		//resp, _ := reader.ReadString('\n')
-		resp := "yes\n"
-		if strings.ToLower(resp) == "y\n" || strings.ToLower(resp) == "yes\n" || strings.ToLower(resp)[:1] == "y" {
-			os.RemoveAll(repoPath)
-			err = initializeRepo(repoPath)
-			if err != nil {
-				return "", err
-			}
+	if !n.opts.ForceReinit {
+		log_repo.Infof("Using existing repo at %s", repoPath)
+		return nil
+	}
+
+	// Read the identity before the repo is moved away, so a wrong
+	// passphrase leaves it untouched
+	var ident *config.Identity
+	if n.opts.KeepIdentity {
+		oldCfg, err := fsrepo.ConfigAt(repoPath)
+		if err != nil {
+			return err
 		}
+		oldIdent, err := ipfs_cmds.DecryptIdentity(oldCfg.Identity, n.opts.Passphrase)
+		if err != nil {
+			return err
+		}
+		ident = &oldIdent
+	}
+
+	backupPath, err := backupRepo(repoPath)
+	if err != nil {
+		return err
 	}
-	return repoPath, nil
+	log_repo.Warningf("Reinitializing repo, the old one was moved to %s", backupPath)
+
+	return doInit(n.opts, ident)
 }
@@ -0,0 +1,90 @@
+package ipfs_core
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/repo/fsrepo"
+)
+
+// Returns the peer ID in the config of the repo at repoPath
+func repoPeerID(t *testing.T, repoPath string) string {
+	cfg, err := fsrepo.ConfigAt(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Identity.PeerID
+}
+
+// Returns the backups of the repo at repoPath
+func repoBackups(t *testing.T, repoPath string) []string {
+	backups, err := filepath.Glob(repoPath + ".backup-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backups
+}
+
+func TestInitKeepsExistingRepo(t *testing.T) {
+	opts := testOptions(t)
+	n, err := Init(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := repoPeerID(t, n.RepoPath)
+
+	if _, err := Init(opts...); err != nil {
+		t.Fatal(err)
+	}
+	if repoPeerID(t, n.RepoPath) != id {
+		t.Error("Init without WithForceReinit replaced the repo")
+	}
+	if backups := repoBackups(t, n.RepoPath); len(backups) != 0 {
+		t.Errorf("Init without WithForceReinit made backups %v", backups)
+	}
+}
+
+func TestForceReinit(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		opts := testOptions(t)
+		n, err := Init(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := repoPeerID(t, n.RepoPath)
+
+		if _, err := Init(append(opts, WithForceReinit(keep))...); err != nil {
+			t.Fatal(err)
+		}
+		if newID := repoPeerID(t, n.RepoPath); (newID == id) != keep {
+			t.Errorf("keepIdentity=%t: identity %s replaced by %s", keep, id, newID)
+		}
+		backups := repoBackups(t, n.RepoPath)
+		if len(backups) != 1 {
+			t.Fatalf("keepIdentity=%t: expected one backup, found %v", keep, backups)
+		}
+		if repoPeerID(t, backups[0]) != id {
+			t.Errorf("keepIdentity=%t: backup does not hold the old repo", keep)
+		}
+	}
+}
+
+func TestReinitWrongPassphrase(t *testing.T) {
+	opts := testOptions(t)
+	n, err := Init(append(opts, WithPassphrase([]byte("right")))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := repoPeerID(t, n.RepoPath)
+
+	// The identity can't be kept, so the repo is left where it is
+	if _, err := Init(append(opts, WithPassphrase([]byte("wrong")), WithForceReinit(true))...); err == nil {
+		t.Fatal("Reinitialized keeping an identity encrypted with another passphrase")
+	}
+	if repoPeerID(t, n.RepoPath) != id {
+		t.Error("Repo was replaced")
+	}
+	if backups := repoBackups(t, n.RepoPath); len(backups) != 0 {
+		t.Errorf("Repo was backed up to %v", backups)
+	}
+}
@@ -0,0 +1,175 @@
+package ipfs_core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-ipfs/repo/fsrepo"
+	"github.com/op/go-logging"
+)
+
+var log_migrate = logging.MustGetLogger("migrate")
+
+var errNoRepoVersion = errors.New("Repo version file is missing")
+
+// A Migration upgrades a repo from version From to version From+1.
+// It runs on the repo directory while the repo is closed.
+type Migration struct {
+	From    int
+	Name    string
+	Migrate func(repoPath string) error
+}
+
+var (
+	migrationsLock sync.Mutex
+	migrations     = make(map[int]Migration)
+)
+
+// RegisterMigration adds a migration to the ones run by Start.
+// It is meant to be called from init functions.
+func RegisterMigration(m Migration) {
+	migrationsLock.Lock()
+	defer migrationsLock.Unlock()
+	if _, found := migrations[m.From]; found {
+		panic(fmt.Sprintf("migration from repo version %d registered twice", m.From))
+	}
+	migrations[m.From] = m
+}
+
+func repoVersionPath(repoPath string) string {
+	return filepath.Join(repoPath, "repover")
+}
+
+// Reads the version in the repover file of the repo
+func readRepoVersion(repoPath string) (int, error) {
+	b, err := ioutil.ReadFile(repoVersionPath(repoPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, errNoRepoVersion
+		}
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid repo version %q", b)
+	}
+	return v, nil
+}
+
+func writeRepoVersion(repoPath string, v int) error {
+	return ioutil.WriteFile(repoVersionPath(repoPath), []byte(strconv.Itoa(v)), 0644)
+}
+
+// Returns the migrations upgrading a repo from version from to version to
+func pendingMigrations(from, to int) ([]Migration, error) {
+	migrationsLock.Lock()
+	defer migrationsLock.Unlock()
+
+	var pending []Migration
+	for v := from; v < to; v++ {
+		m, found := migrations[v]
+		if !found {
+			return nil, fmt.Errorf("No migration from repo version %d to %d", v, v+1)
+		}
+		pending = append(pending, m)
+	}
+	return pending, nil
+}
+
+// Returns the oldest version there are migrations up to current from. It
+// is assumed for repos created before the version file was written.
+func oldestRepoVersion(current int) int {
+	migrationsLock.Lock()
+	defer migrationsLock.Unlock()
+	v := current
+	for v > 0 {
+		if _, found := migrations[v-1]; !found {
+			break
+		}
+		v--
+	}
+	return v
+}
+
+// migrateRepo brings the repo at repoPath up to RepoVersion. Repos without
+// a version file are migrated from oldestRepoVersion.
+// The repo is copied to a backup directory first; if a migration fails the
+// repo is restored from it. Repos newer than RepoVersion are refused.
+func migrateRepo(repoPath string) error {
+	current, err := strconv.Atoi(RepoVersion)
+	if err != nil {
+		return err
+	}
+	ver, err := readRepoVersion(repoPath)
+	if err == errNoRepoVersion {
+		// Let fsrepo.Open report directories which are no repo
+		if !fsrepo.IsInitialized(repoPath) {
+			return nil
+		}
+		ver = oldestRepoVersion(current)
+		log_migrate.Warningf("Repo version file is missing, assuming version %d", ver)
+		if ver == current {
+			return writeRepoVersion(repoPath, ver)
+		}
+	} else if err != nil {
+		return err
+	}
+	if ver > current {
+		return fmt.Errorf("Repo version %d is newer than the %d supported by this binary, please upgrade", ver, current)
+	}
+	if ver == current {
+		return nil
+	}
+
+	pending, err := pendingMigrations(ver, current)
+	if err != nil {
+		return err
+	}
+
+	locked, err := fsrepo.LockedByOtherProcess(repoPath)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return fmt.Errorf("Repo %s is in use by another process", repoPath)
+	}
+
+	backup := fmt.Sprintf("%s.migration-%d-%s", filepath.Clean(repoPath), ver, time.Now().Format("20060102-150405"))
+	log_migrate.Infof("Backing up repo version %d to %s", ver, backup)
+	if err := copyDir(repoPath, backup); err != nil {
+		os.RemoveAll(backup)
+		return err
+	}
+
+	for _, m := range pending {
+		log_migrate.Infof("Migrating repo from version %d to %d: %s", m.From, m.From+1, m.Name)
+		err := m.Migrate(repoPath)
+		if err == nil {
+			err = writeRepoVersion(repoPath, m.From+1)
+		}
+		if err != nil {
+			log_migrate.Errorf("Migration from version %d failed: %s", m.From, err)
+			if rerr := restoreRepo(repoPath, backup); rerr != nil {
+				return fmt.Errorf("migration failed: %s; restoring the backup at %s failed too: %s", err, backup, rerr)
+			}
+			return fmt.Errorf("migration from repo version %d failed, repo restored: %s", m.From, err)
+		}
+	}
+	log_migrate.Infof("Repo migrated to version %d, the backup is kept at %s", current, backup)
+	return nil
+}
+
+// Replaces the repo at repoPath with its backup
+func restoreRepo(repoPath, backup string) error {
+	if err := os.RemoveAll(repoPath); err != nil {
+		return err
+	}
+	return os.Rename(backup, repoPath)
+}
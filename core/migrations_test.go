@@ -0,0 +1,82 @@
+package ipfs_core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Replaces the registered migrations for the duration of a test
+func withMigrations(t *testing.T, ms ...Migration) {
+	migrationsLock.Lock()
+	saved := migrations
+	migrations = make(map[int]Migration)
+	migrationsLock.Unlock()
+	for _, m := range ms {
+		RegisterMigration(m)
+	}
+	t.Cleanup(func() {
+		migrationsLock.Lock()
+		migrations = saved
+		migrationsLock.Unlock()
+	})
+}
+
+// Returns a directory fsrepo considers a repo, without a version file
+func unversionedRepo(t *testing.T) string {
+	dir := filepath.Join(t.TempDir(), "repo")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMigrateUnversionedRepo(t *testing.T) {
+	current, err := strconv.Atoi(RepoVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withMigrations(t)
+	dir := unversionedRepo(t)
+	if err := migrateRepo(dir); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := readRepoVersion(dir); err != nil || v != current {
+		t.Errorf("Repo without migrations is at version %d (%v), expected %d", v, err, current)
+	}
+
+	// Migrated from the oldest version there are migrations from
+	var ran []int
+	migrate := func(from int) Migration {
+		return Migration{From: from, Name: "test", Migrate: func(string) error {
+			ran = append(ran, from)
+			return nil
+		}}
+	}
+	withMigrations(t, migrate(current-2), migrate(current-1))
+	dir = unversionedRepo(t)
+	if err := migrateRepo(dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 2 || ran[0] != current-2 || ran[1] != current-1 {
+		t.Errorf("Ran the migrations from %v", ran)
+	}
+	if v, err := readRepoVersion(dir); err != nil || v != current {
+		t.Errorf("Migrated repo is at version %d (%v), expected %d", v, err, current)
+	}
+
+	// Not a repo, left to fsrepo.Open
+	dir = t.TempDir()
+	if err := migrateRepo(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readRepoVersion(dir); err != errNoRepoVersion {
+		t.Errorf("Version file written outside of a repo: %v", err)
+	}
+}
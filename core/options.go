@@ -0,0 +1,416 @@
+package ipfs_core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-ipfs/repo/config"
+
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
+
+	ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+// Environment variables overriding the default options
+const (
+	EnvRepoPath    = "SATURN_PATH"         // repo path
+	EnvSwarmAddrs  = "SATURN_SWARM_ADDRS"  // comma separated swarm multiaddrs
+	EnvGatewayAddr = "SATURN_GATEWAY_ADDR" // gateway multiaddr
+	EnvBootstrap   = "SATURN_BOOTSTRAP"    // comma separated bootstrap peers, empty for none
+	EnvKeyType     = "SATURN_KEY_TYPE"     // identity key type: rsa, ed25519 or secp256k1
+)
+
+// Options describes a single node instance.
+// Zero fields are unset: Init falls back to the built-in defaults for them
+// and Start keeps whatever the repo config already holds.
+type Options struct {
+	// The path to the repo in the file system
+	RepoPath string
+
+	// Identity key type and size, used by Init only
+	KeyType int
+	KeyBits int
+
+	// Seed the identity key is derived from, used by Init only
+	Seed []byte
+
+	// Existing identity key in the libp2p protobuf encoding, used by Init only
+	IdentityKey []byte
+
+	// Passphrase encrypting the identity key and the keystore. Init encrypts
+	// the keys of a new repo with it and Start uses it to unlock them.
+	Passphrase []byte
+
+	// Make Init back up and replace an existing repo, optionally keeping
+	// its identity
+	ForceReinit  bool
+	KeepIdentity bool
+
+	// Swarm listening addresses and gateway address
+	SwarmAddrs  []string
+	GatewayAddr string
+
+	// Bootstrap peers. A non-nil empty list disables bootstrapping.
+	Bootstrap []string
+
+	// Datastore spec, used by Init only
+	DatastoreSpec map[string]interface{}
+
+	// Local peer discovery
+	MDNS *config.MDNS
+
+	// Lifetime of our IPNS records and how often they are republished
+	IpnsRecordLifetime  time.Duration
+	IpnsRepublishPeriod time.Duration
+
+	// Datastore size limits and garbage collection
+	StorageMax         string
+	StorageGCWatermark int64
+	GCPeriod           time.Duration
+
+	// Run the periodic garbage collector while the node is started
+	PeriodicGC bool
+
+	// How often expired pins are released while the node is started, 0
+	// for never, and whether the garbage collector runs after releasing any
+	PinSweepPeriod time.Duration
+	PinSweepGC     bool
+
+	// Clock pin expiry and push times are measured with, the system clock
+	// if nil
+	Clock ipfs_cmds.Clock
+}
+
+// An Option sets one or more fields of Options
+type Option func(*Options) error
+
+// NewOptions returns the default options, overridden by the environment
+// and then by opts.
+func NewOptions(opts ...Option) (Options, error) {
+	o := Options{
+		KeyType: libp2p.RSA,
+		KeyBits: BitForKeyPair,
+	}
+	repoPath, err := getRepoPath()
+	if err != nil {
+		return o, err
+	}
+	o.RepoPath = repoPath
+
+	if err := o.applyEnv(); err != nil {
+		return o, err
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+func (o *Options) applyEnv() error {
+	var envOpts []Option
+	if v, ok := os.LookupEnv(EnvRepoPath); ok && v != "" {
+		envOpts = append(envOpts, WithRepoPath(v))
+	}
+	if v, ok := os.LookupEnv(EnvSwarmAddrs); ok && v != "" {
+		envOpts = append(envOpts, WithSwarmAddrs(splitList(v)...))
+	}
+	if v, ok := os.LookupEnv(EnvGatewayAddr); ok && v != "" {
+		envOpts = append(envOpts, WithGatewayAddr(v))
+	}
+	if v, ok := os.LookupEnv(EnvKeyType); ok && v != "" {
+		typ, err := ipfs_cmds.ParseKeyType(v)
+		if err != nil {
+			return fmt.Errorf("invalid environment: %s", err)
+		}
+		envOpts = append(envOpts, WithKey(typ, o.KeyBits))
+	}
+	if v, ok := os.LookupEnv(EnvBootstrap); ok {
+		envOpts = append(envOpts, WithBootstrap(splitList(v)...))
+	}
+	for _, opt := range envOpts {
+		if err := opt(o); err != nil {
+			return fmt.Errorf("invalid environment: %s", err)
+		}
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+// WithRepoPath sets the repo path
+func WithRepoPath(path string) Option {
+	return func(o *Options) error {
+		if path == "" {
+			return errors.New("Repo path must not be empty")
+		}
+		o.RepoPath = path
+		return nil
+	}
+}
+
+// WithKey sets the type and size of the identity key generated by Init.
+// The size is only used by RSA keys.
+func WithKey(typ, bits int) Option {
+	return func(o *Options) error {
+		switch typ {
+		case libp2p.RSA, libp2p.Ed25519, libp2p.Secp256k1:
+		default:
+			return libp2p.ErrBadKeyType
+		}
+		o.KeyType = typ
+		o.KeyBits = bits
+		return nil
+	}
+}
+
+// WithSeed makes Init derive an Ed25519 identity from seed, so the same
+// peer ID and IPNS name can be recovered on another machine
+func WithSeed(seed []byte) Option {
+	return func(o *Options) error {
+		if len(seed) == 0 {
+			return errors.New("Seed must not be empty")
+		}
+		o.Seed = seed
+		return nil
+	}
+}
+
+// WithMnemonic is WithSeed with the seed of a BIP39 mnemonic and password
+func WithMnemonic(mnemonic, password string) Option {
+	return func(o *Options) error {
+		seed, err := ipfs_cmds.SeedFromMnemonic(mnemonic, password)
+		if err != nil {
+			return err
+		}
+		o.Seed = seed
+		return nil
+	}
+}
+
+// WithIdentityKey makes Init use an existing private key, in format, as the
+// identity of the node instead of generating one
+func WithIdentityKey(key []byte, format ipfs_cmds.KeyFormat) Option {
+	return func(o *Options) error {
+		sk, err := ipfs_cmds.ImportPrivateKey(key, format)
+		if err != nil {
+			return err
+		}
+		skbytes, err := sk.Bytes()
+		if err != nil {
+			return err
+		}
+		o.IdentityKey = skbytes
+		return nil
+	}
+}
+
+// WithPassphrase sets the passphrase of the identity key and keystore
+func WithPassphrase(passphrase []byte) Option {
+	return func(o *Options) error {
+		if len(passphrase) == 0 {
+			return errors.New("Passphrase must not be empty")
+		}
+		o.Passphrase = passphrase
+		return nil
+	}
+}
+
+// WithForceReinit makes Init replace an existing repo. The old repo is
+// backed up first and its identity is reused if keepIdentity is true.
+func WithForceReinit(keepIdentity bool) Option {
+	return func(o *Options) error {
+		o.ForceReinit = true
+		o.KeepIdentity = keepIdentity
+		return nil
+	}
+}
+
+// WithSwarmAddrs sets the swarm listening addresses
+func WithSwarmAddrs(addrs ...string) Option {
+	return func(o *Options) error {
+		for _, addr := range addrs {
+			if _, err := ma.NewMultiaddr(addr); err != nil {
+				return fmt.Errorf("invalid swarm address %s: %s", addr, err)
+			}
+		}
+		o.SwarmAddrs = addrs
+		return nil
+	}
+}
+
+// WithSwarmPorts listens on all interfaces on a TCP and a websocket port.
+// A zero port lets the OS pick a free one.
+func WithSwarmPorts(tcp, ws int) Option {
+	return WithSwarmAddrs(
+		fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", tcp),
+		fmt.Sprintf("/ip6/::/tcp/%d", tcp),
+		fmt.Sprintf("/ip4/0.0.0.0/tcp/%d/ws", ws),
+		fmt.Sprintf("/ip6/::/tcp/%d/ws", ws),
+	)
+}
+
+// WithGatewayAddr sets the gateway address
+func WithGatewayAddr(addr string) Option {
+	return func(o *Options) error {
+		if _, err := ma.NewMultiaddr(addr); err != nil {
+			return fmt.Errorf("invalid gateway address %s: %s", addr, err)
+		}
+		o.GatewayAddr = addr
+		return nil
+	}
+}
+
+// WithBootstrap sets the bootstrap peers. No peers disables bootstrapping.
+func WithBootstrap(addrs ...string) Option {
+	return func(o *Options) error {
+		if _, err := config.ParseBootstrapPeers(addrs); err != nil {
+			return err
+		}
+		o.Bootstrap = append([]string{}, addrs...)
+		return nil
+	}
+}
+
+// WithDatastoreSpec sets the datastore spec written by Init
+func WithDatastoreSpec(spec map[string]interface{}) Option {
+	return func(o *Options) error {
+		if spec == nil {
+			return errors.New("Datastore spec must not be nil")
+		}
+		o.DatastoreSpec = spec
+		return nil
+	}
+}
+
+// WithMDNS enables or disables local discovery, interval is in seconds
+func WithMDNS(enabled bool, interval int) Option {
+	return func(o *Options) error {
+		if enabled && interval <= 0 {
+			return errors.New("MDNS interval must be positive")
+		}
+		o.MDNS = &config.MDNS{Enabled: enabled, Interval: interval}
+		return nil
+	}
+}
+
+// WithIpnsLifetimes sets the IPNS record lifetime and republish period
+func WithIpnsLifetimes(recordLifetime, republishPeriod time.Duration) Option {
+	return func(o *Options) error {
+		if recordLifetime < 0 || republishPeriod < 0 {
+			return errors.New("IPNS lifetimes must not be negative")
+		}
+		o.IpnsRecordLifetime = recordLifetime
+		o.IpnsRepublishPeriod = republishPeriod
+		return nil
+	}
+}
+
+// WithStorage sets the datastore size limit (eg. "10GB") and the
+// percentage of it above which the garbage collector kicks in
+func WithStorage(max string, gcWatermark int64) Option {
+	return func(o *Options) error {
+		if gcWatermark < 0 || gcWatermark > 100 {
+			return errors.New("GC watermark must be a percentage")
+		}
+		o.StorageMax = max
+		o.StorageGCWatermark = gcWatermark
+		return nil
+	}
+}
+
+// WithGC runs the garbage collector every period while the node is started
+func WithGC(period time.Duration) Option {
+	return func(o *Options) error {
+		if period <= 0 {
+			return errors.New("GC period must be positive")
+		}
+		o.GCPeriod = period
+		o.PeriodicGC = true
+		return nil
+	}
+}
+
+// WithPinSweep releases expired pins every period while the node is
+// started, running the garbage collector afterwards if gc is set
+func WithPinSweep(period time.Duration, gc bool) Option {
+	return func(o *Options) error {
+		if period <= 0 {
+			return errors.New("Pin sweep period must be positive")
+		}
+		o.PinSweepPeriod = period
+		o.PinSweepGC = gc
+		return nil
+	}
+}
+
+// WithClock sets the clock pin expiry and push times are measured with
+func WithClock(clock ipfs_cmds.Clock) Option {
+	return func(o *Options) error {
+		if clock == nil {
+			return errors.New("Clock must not be nil")
+		}
+		o.Clock = clock
+		return nil
+	}
+}
+
+// applyConfig writes the set options to cfg. Options which only make sense
+// for a new repo are skipped unless init is true.
+func (o Options) applyConfig(cfg *config.Config, init bool) (changed bool, err error) {
+	if len(o.SwarmAddrs) > 0 {
+		cfg.Addresses.Swarm = o.SwarmAddrs
+		changed = true
+	}
+	if o.GatewayAddr != "" {
+		cfg.Addresses.Gateway = o.GatewayAddr
+		changed = true
+	}
+	if o.Bootstrap != nil {
+		peers, err := config.ParseBootstrapPeers(o.Bootstrap)
+		if err != nil {
+			return false, err
+		}
+		cfg.Bootstrap = config.BootstrapPeerStrings(peers)
+		changed = true
+	}
+	if init && o.DatastoreSpec != nil {
+		cfg.Datastore.Spec = o.DatastoreSpec
+		changed = true
+	}
+	if o.MDNS != nil {
+		cfg.Discovery.MDNS = *o.MDNS
+		changed = true
+	}
+	if o.IpnsRecordLifetime != 0 {
+		cfg.Ipns.RecordLifetime = o.IpnsRecordLifetime.String()
+		changed = true
+	}
+	if o.IpnsRepublishPeriod != 0 {
+		cfg.Ipns.RepublishPeriod = o.IpnsRepublishPeriod.String()
+		changed = true
+	}
+	if o.StorageMax != "" {
+		cfg.Datastore.StorageMax = o.StorageMax
+		cfg.Datastore.StorageGCWatermark = o.StorageGCWatermark
+		changed = true
+	}
+	if o.GCPeriod != 0 {
+		cfg.Datastore.GCPeriod = o.GCPeriod.String()
+		changed = true
+	}
+	return changed, nil
+}
@@ -0,0 +1,144 @@
+package ipfs_core
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/repo/fsrepo"
+
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+const testPeer = "/ip4/127.0.0.1/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"
+
+// Unsets the option environment variables for the duration of the test
+func clearEnv(t *testing.T) {
+	for _, v := range []string{EnvRepoPath, EnvSwarmAddrs, EnvGatewayAddr, EnvBootstrap, EnvKeyType} {
+		t.Setenv(v, "")
+		os.Unsetenv(v)
+	}
+}
+
+func TestOptionDefaults(t *testing.T) {
+	clearEnv(t)
+	o, err := NewOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoPath, err := getRepoPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.RepoPath != repoPath || o.KeyType != libp2p.RSA || o.KeyBits != BitForKeyPair {
+		t.Errorf("Unexpected defaults %+v", o)
+	}
+	// Unset options leave the repo config alone
+	if o.SwarmAddrs != nil || o.GatewayAddr != "" || o.Bootstrap != nil || o.MDNS != nil {
+		t.Errorf("Defaults set config options: %+v", o)
+	}
+}
+
+func TestOptionPrecedence(t *testing.T) {
+	clearEnv(t)
+	t.Setenv(EnvRepoPath, "/env/repo")
+	t.Setenv(EnvSwarmAddrs, "/ip4/127.0.0.1/tcp/1, /ip4/127.0.0.1/tcp/2")
+	t.Setenv(EnvGatewayAddr, "/ip4/127.0.0.1/tcp/3")
+	t.Setenv(EnvBootstrap, testPeer)
+	t.Setenv(EnvKeyType, "ed25519")
+
+	// The environment overrides the defaults
+	o, err := NewOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.RepoPath != "/env/repo" ||
+		!reflect.DeepEqual(o.SwarmAddrs, []string{"/ip4/127.0.0.1/tcp/1", "/ip4/127.0.0.1/tcp/2"}) ||
+		o.GatewayAddr != "/ip4/127.0.0.1/tcp/3" ||
+		!reflect.DeepEqual(o.Bootstrap, []string{testPeer}) ||
+		o.KeyType != libp2p.Ed25519 {
+		t.Errorf("Environment not applied: %+v", o)
+	}
+
+	// Explicit options override the environment
+	o, err = NewOptions(
+		WithRepoPath("/opt/repo"),
+		WithSwarmAddrs("/ip4/127.0.0.1/tcp/4"),
+		WithGatewayAddr("/ip4/127.0.0.1/tcp/5"),
+		WithBootstrap(),
+		WithKey(libp2p.Secp256k1, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.RepoPath != "/opt/repo" ||
+		!reflect.DeepEqual(o.SwarmAddrs, []string{"/ip4/127.0.0.1/tcp/4"}) ||
+		o.GatewayAddr != "/ip4/127.0.0.1/tcp/5" ||
+		o.Bootstrap == nil || len(o.Bootstrap) != 0 ||
+		o.KeyType != libp2p.Secp256k1 {
+		t.Errorf("Options did not override the environment: %+v", o)
+	}
+
+	// An empty bootstrap list in the environment disables bootstrapping,
+	// other empty variables are ignored
+	t.Setenv(EnvBootstrap, "")
+	t.Setenv(EnvSwarmAddrs, "")
+	o, err = NewOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Bootstrap == nil || len(o.Bootstrap) != 0 || o.SwarmAddrs != nil {
+		t.Errorf("Empty environment variables: %+v", o)
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	clearEnv(t)
+	t.Setenv(EnvKeyType, "dsa")
+	if _, err := NewOptions(); err == nil {
+		t.Error("Accepted an invalid key type in the environment")
+	}
+	clearEnv(t)
+	t.Setenv(EnvSwarmAddrs, "not an address")
+	if _, err := NewOptions(); err == nil {
+		t.Error("Accepted an invalid swarm address in the environment")
+	}
+	clearEnv(t)
+	for _, opt := range []Option{
+		WithRepoPath(""),
+		WithGatewayAddr("not an address"),
+		WithBootstrap("/ip4/127.0.0.1/tcp/4001"),
+		WithMDNS(true, 0),
+		WithStorage("10GB", 101),
+	} {
+		if _, err := NewOptions(opt); err == nil {
+			t.Errorf("Accepted invalid options %+v", opt)
+		}
+	}
+}
+
+func TestStartAppliesOptions(t *testing.T) {
+	clearEnv(t)
+	opts := testOptions(t)
+	n, err := Init(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Options given to Start are written to the config of the existing repo
+	n = startTestNode(t, append(opts, WithGatewayAddr("/ip4/127.0.0.1/tcp/8085"), WithBootstrap(testPeer))...)
+	if err := n.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := fsrepo.ConfigAt(n.RepoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addresses.Gateway != "/ip4/127.0.0.1/tcp/8085" || !reflect.DeepEqual(cfg.Bootstrap, []string{testPeer}) {
+		t.Errorf("Options not written to the config: gateway=%s bootstrap=%v", cfg.Addresses.Gateway, cfg.Bootstrap)
+	}
+	if cfg.Discovery.MDNS.Enabled {
+		t.Error("MDNS is enabled")
+	}
+}
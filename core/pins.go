@@ -0,0 +1,75 @@
+package ipfs_core
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core/corerepo"
+	"github.com/op/go-logging"
+
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
+)
+
+var log_pins = logging.MustGetLogger("pins")
+
+// Returns the clock pin expiry is measured with
+func (n *SaturnNode) clock() ipfs_cmds.Clock {
+	if n.opts.Clock != nil {
+		return n.opts.Clock
+	}
+	return ipfs_cmds.SystemClock
+}
+
+// PinWithTTL pins hash recursively for ttl. Pinning it again extends the
+// pin if the new TTL ends later, see ipfs_cmds.PinWithTTL. The pin is released by the sweeper once it expired, see
+// WithPinSweep, or by SweepExpiredPins.
+func (n *SaturnNode) PinWithTTL(ctx context.Context, hash string, ttl time.Duration) (ipfs_cmds.LabelledPin, error) {
+	p, err := ipfs_cmds.PinWithTTL(ctx, n.Context, n.clock(), hash, ttl)
+	if err == nil {
+		n.TriggerPush()
+	}
+	return p, err
+}
+
+// SweepExpiredPins releases the pins which expired by now and returns them
+func (n *SaturnNode) SweepExpiredPins(ctx context.Context) ([]ipfs_cmds.LabelledPin, error) {
+	swept, err := ipfs_cmds.SweepExpiredPins(ctx, n.Context, n.clock().Now())
+	if len(swept) > 0 {
+		n.TriggerPush()
+	}
+	return swept, err
+}
+
+// Releases expired pins every period until ctx is done. The garbage
+// collector runs after pins were released if gc is set.
+func (n *SaturnNode) sweepPins(ctx context.Context, cctx commands.Context, period time.Duration, gc bool) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		swept, err := ipfs_cmds.SweepExpiredPins(ctx, cctx, n.clock().Now())
+		if err != nil {
+			log_pins.Error(err)
+		}
+		if len(swept) == 0 {
+			continue
+		}
+		log_pins.Infof("Released %d expired pins", len(swept))
+		n.TriggerPush()
+		if !gc {
+			continue
+		}
+		nd, err := cctx.GetNode()
+		if err == nil {
+			err = corerepo.GarbageCollect(nd, ctx)
+		}
+		if err != nil {
+			log_pins.Error(err)
+		}
+	}
+}
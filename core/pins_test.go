@@ -0,0 +1,53 @@
+package ipfs_core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cid "gx/ipfs/QmNp85zy9RLrQ5oQD4hPyS39ezrrXpcaa7R4Y9kxdWQLLQ/go-cid"
+	mocknet "gx/ipfs/QmRQ76P5dgvxTujhfPsCRAG83rC15jgb1G9bKLuomuC6dQ/go-libp2p/p2p/net/mock"
+
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
+)
+
+func TestSweepPins(t *testing.T) {
+	ctx := context.Background()
+	clock := ipfs_cmds.NewMockClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	n := mockSaturnNode(t, mocknet.New(ctx), clock)
+	trigger := make(chan struct{}, 1)
+	n.pushTrigger = append(n.pushTrigger, trigger)
+
+	hash := addData(t, n, "expires", false)
+	if _, err := n.PinWithTTL(ctx, hash, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	<-trigger
+	go n.sweepPins(n.ctx, n.Context, time.Millisecond, false)
+
+	// Sweeps happen, but the mock clock has not passed the expiry
+	time.Sleep(time.Millisecond * 50)
+	if !hasLabelledPin(t, n, hash) {
+		t.Fatal("Released a pin before it expired")
+	}
+	select {
+	case <-trigger:
+		t.Error("Triggered a push without releasing pins")
+	default:
+	}
+
+	clock.Add(time.Hour)
+	waitFor(t, "the expired pin to be released", func() bool { return !hasLabelledPin(t, n, hash) })
+	c, err := cid.Decode(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, pinned, err := n.IpfsNode.Pinning.IsPinned(c); err != nil || pinned {
+		t.Errorf("Expired pin is still pinned: %v", err)
+	}
+	select {
+	case <-trigger:
+	case <-time.After(time.Second * 10):
+		t.Error("Releasing pins did not trigger a push")
+	}
+}
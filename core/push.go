@@ -0,0 +1,267 @@
+package ipfs_core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	ipfsrepo "github.com/ipfs/go-ipfs/repo"
+	iaddr "github.com/ipfs/go-ipfs/thirdparty/ipfsaddr"
+	"github.com/op/go-logging"
+
+	pstore "gx/ipfs/QmPgDWmTmuzvP7QE5zwo1TmjbJme9pmZHNujB2453jkCTr/go-libp2p-peerstore"
+	ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+	"gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
+)
+
+var log_push = logging.MustGetLogger("push")
+
+// Variables rather than constants so that tests can shorten them
+var (
+	// How often the roots are compared to those pushed last, to catch pins
+	// changed with ipfs_cmds directly
+	pushCheckPeriod = time.Minute
+
+	// Delay before retrying a failed push, doubled after each failure
+	pushMinBackoff = time.Second * 5
+	pushMaxBackoff = time.Minute * 10
+)
+
+const (
+	// Time a push may take, including connecting to the node
+	pushTimeout = time.Minute * 10
+
+	// Default limits of what each node may push to this one
+	defaultMaxPushBlocks = 1 << 20
+	defaultMaxPushBytes  = 10 << 30
+)
+
+// DataSharing is the section of the repo config, next to those of go-ipfs,
+// which sets up replication:
+//
+//	"DataSharing": {
+//		"PushTo": ["<peer ID>", "/ip4/1.2.3.4/tcp/4001/ipfs/<peer ID>"],
+//		"AcceptStoreRequests": true,
+//		"AcceptFrom": ["<peer ID>"],
+//		"MaxPushBlocks": 1048576,
+//		"MaxPushBytes": 10737418240
+//	}
+type DataSharing struct {
+	// Nodes our root and pins are pushed to, peer IDs or multiaddrs
+	// ending with /ipfs/<peer ID>
+	PushTo []string
+
+	// Let the nodes of AcceptFrom push data to this one, off by default
+	AcceptStoreRequests bool
+
+	// Nodes which may push data to this one, peer IDs or multiaddrs
+	AcceptFrom []string
+
+	// Blocks and bytes each node may have stored here, defaults if zero
+	MaxPushBlocks int
+	MaxPushBytes  int64
+}
+
+// Reads the DataSharing section of the config of r, which is optional
+func readDataSharing(r ipfsrepo.Repo) (DataSharing, error) {
+	sharing := DataSharing{
+		MaxPushBlocks: defaultMaxPushBlocks,
+		MaxPushBytes:  defaultMaxPushBytes,
+	}
+	v, err := r.GetConfigKey("DataSharing")
+	if err != nil {
+		// The section is missing
+		return sharing, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return sharing, err
+	}
+	if err := json.Unmarshal(b, &sharing); err != nil {
+		return sharing, fmt.Errorf("Invalid DataSharing config: %s", err)
+	}
+	if sharing.MaxPushBlocks <= 0 {
+		sharing.MaxPushBlocks = defaultMaxPushBlocks
+	}
+	if sharing.MaxPushBytes <= 0 {
+		sharing.MaxPushBytes = defaultMaxPushBytes
+	}
+	return sharing, nil
+}
+
+// Parses the nodes of the DataSharing config
+func parsePushNodes(nodeList []string) ([]pstore.PeerInfo, error) {
+	var nodes []pstore.PeerInfo
+	for _, s := range nodeList {
+		if p, err := peer.IDB58Decode(s); err == nil {
+			nodes = append(nodes, pstore.PeerInfo{ID: p})
+			continue
+		}
+		addr, err := iaddr.ParseString(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid node %s in DataSharing config: %s", s, err)
+		}
+		nodes = append(nodes, pstore.PeerInfo{
+			ID:    addr.ID(),
+			Addrs: []ma.Multiaddr{addr.Transport()},
+		})
+	}
+	return nodes, nil
+}
+
+// PushStatus is the replication state of a push node
+type PushStatus struct {
+	Peer      string
+	Synced    bool      // holds our current root and pins
+	LastPush  time.Time // of the last successful push, zero if none
+	Blocks    int       // sent by the last successful push
+	Failures  int       // consecutive failed pushes
+	LastErr   error     // of the last failed push
+	NextRetry time.Time // zero unless retrying
+}
+
+// PushStatus returns the replication state of each push node, sorted by
+// peer ID
+func (n *SaturnNode) PushStatus() []PushStatus {
+	n.pushLock.Lock()
+	defer n.pushLock.Unlock()
+	var out []PushStatus
+	for _, s := range n.pushStatus {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Peer < out[j].Peer
+	})
+	return out
+}
+
+// TriggerPush makes the push nodes compare their roots to ours without
+// waiting for the next check. The root is compared on every change made
+// through the SaturnNode already.
+func (n *SaturnNode) TriggerPush() {
+	n.pushLock.Lock()
+	defer n.pushLock.Unlock()
+	for _, c := range n.pushTrigger {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Starts replicating to each of nodes until ctx is done
+func (n *SaturnNode) startPush(ctx context.Context, nodes []pstore.PeerInfo) {
+	n.pushLock.Lock()
+	defer n.pushLock.Unlock()
+	n.pushStatus = make(map[peer.ID]*PushStatus)
+	n.pushTrigger = nil
+	for _, pi := range nodes {
+		if _, ok := n.pushStatus[pi.ID]; ok {
+			continue
+		}
+		n.pushStatus[pi.ID] = &PushStatus{Peer: pi.ID.Pretty()}
+		trigger := make(chan struct{}, 1)
+		n.pushTrigger = append(n.pushTrigger, trigger)
+		go n.replicate(ctx, pi, trigger)
+	}
+}
+
+// Returns the root hash and the recursive pins, sorted
+func (n *SaturnNode) pushRoots() []string {
+	var roots []string
+	if root := n.Root(); root != "" {
+		roots = append(roots, root)
+	}
+	// The pinner reads its keys without locking, pinning holds the pin lock
+	// which the GC lock excludes
+	unlocker := n.IpfsNode.Blockstore.GCLock()
+	keys := n.IpfsNode.Pinning.RecursiveKeys()
+	unlocker.Unlock()
+	for _, c := range keys {
+		roots = append(roots, c.String())
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// Pushes our roots to pi whenever they changed, until ctx is done. Failed
+// pushes are retried with an exponential backoff.
+func (n *SaturnNode) replicate(ctx context.Context, pi pstore.PeerInfo, trigger <-chan struct{}) {
+	check := time.NewTicker(pushCheckPeriod)
+	defer check.Stop()
+
+	var synced string
+	var backoff time.Duration
+	for {
+		roots := n.pushRoots()
+		key := strings.Join(roots, " ")
+		if key == synced {
+			select {
+			case <-trigger:
+			case <-check.C:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		n.pushLock.Lock()
+		n.pushStatus[pi.ID].Synced = false
+		n.pushLock.Unlock()
+		res, err := n.pushTo(ctx, pi, roots)
+		if ctx.Err() != nil {
+			return
+		}
+		n.pushLock.Lock()
+		status := n.pushStatus[pi.ID]
+		if err != nil {
+			if backoff *= 2; backoff == 0 {
+				backoff = pushMinBackoff
+			} else if backoff > pushMaxBackoff {
+				backoff = pushMaxBackoff
+			}
+			status.Synced = false
+			status.Failures++
+			status.LastErr = err
+			status.NextRetry = n.clock().Now().Add(backoff)
+		} else {
+			synced = key
+			backoff = 0
+			*status = PushStatus{
+				Peer:     status.Peer,
+				Synced:   true,
+				LastPush: n.clock().Now(),
+				Blocks:   res.Blocks,
+			}
+		}
+		n.pushLock.Unlock()
+		if err == nil {
+			log_push.Infof("Pushed %d blocks to %s", res.Blocks, pi.ID.Pretty())
+			continue
+		}
+
+		log_push.Warningf("Push to %s failed, retrying in %s: %s", pi.ID.Pretty(), backoff, err)
+		retry := time.NewTimer(backoff)
+		select {
+		case <-retry.C:
+		case <-ctx.Done():
+			retry.Stop()
+			return
+		}
+	}
+}
+
+// Connects to pi and pushes roots to it
+func (n *SaturnNode) pushTo(ctx context.Context, pi pstore.PeerInfo, roots []string) (ipfs_cmds.PushResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+	if err := n.IpfsNode.PeerHost.Connect(ctx, pi); err != nil {
+		return ipfs_cmds.PushResult{}, err
+	}
+	return ipfs_cmds.Push(ctx, n.Context, pi.ID, roots)
+}
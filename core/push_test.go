@@ -0,0 +1,163 @@
+package ipfs_core
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	pstore "gx/ipfs/QmPgDWmTmuzvP7QE5zwo1TmjbJme9pmZHNujB2453jkCTr/go-libp2p-peerstore"
+	mocknet "gx/ipfs/QmRQ76P5dgvxTujhfPsCRAG83rC15jgb1G9bKLuomuC6dQ/go-libp2p/p2p/net/mock"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
+)
+
+// Returns a started SaturnNode on mn without a repo on disk
+func mockSaturnNode(t *testing.T, mn mocknet.Mocknet, clock ipfs_cmds.Clock) *SaturnNode {
+	cctx, err := ipfs_cmds.MockNetCmdsCtx(mn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := cctx.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		nd.Close()
+	})
+	return &SaturnNode{
+		Context:  cctx,
+		IpfsNode: nd,
+		opts:     Options{Clock: clock},
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		started:  true,
+	}
+}
+
+// Adds data to n, pinned unless pin is false
+func addData(t *testing.T, n *SaturnNode, data string, pin bool) string {
+	opts := ipfs_cmds.DefaultAddOptions()
+	opts.Pin = pin
+	hash, _, err := ipfs_cmds.AddReader(context.Background(), n.Context, bytes.NewReader([]byte(data)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// Waits until cond holds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second * 10)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Returns whether n holds a labelled pin of hash
+func hasLabelledPin(t *testing.T, n *SaturnNode, hash string) bool {
+	pins, err := ipfs_cmds.ListLabelledPins(context.Background(), n.Context, ipfs_cmds.PinQuery{Cid: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(pins) > 0
+}
+
+// The delay before the next retry after failures failed pushes
+func expectedBackoff(failures int) time.Duration {
+	backoff := pushMinBackoff << uint(failures-1)
+	if backoff > pushMaxBackoff {
+		backoff = pushMaxBackoff
+	}
+	return backoff
+}
+
+func TestReplicate(t *testing.T) {
+	defer func(check, minBackoff, maxBackoff time.Duration) {
+		pushCheckPeriod, pushMinBackoff, pushMaxBackoff = check, minBackoff, maxBackoff
+	}(pushCheckPeriod, pushMinBackoff, pushMaxBackoff)
+	// Pushes are only made when triggered or retried
+	pushCheckPeriod = time.Hour
+	pushMinBackoff = time.Millisecond * 50
+	pushMaxBackoff = time.Millisecond * 200
+
+	ctx := context.Background()
+	mn := mocknet.New(ctx)
+	clock := ipfs_cmds.NewMockClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	a := mockSaturnNode(t, mn, clock)
+	b := mockSaturnNode(t, mn, clock)
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	first := addData(t, a, "first", true)
+	a.startPush(a.ctx, []pstore.PeerInfo{{
+		ID:    b.IpfsNode.Identity,
+		Addrs: b.IpfsNode.PeerHost.Addrs(),
+	}})
+	status := func() PushStatus {
+		s := a.PushStatus()
+		if len(s) != 1 || s[0].Peer != b.IpfsNode.Identity.Pretty() {
+			t.Fatalf("Unexpected push status %+v", s)
+		}
+		return s[0]
+	}
+
+	// b does not accept pushes yet, the backoff grows up to the maximum
+	waitFor(t, "failed pushes", func() bool { return status().Failures >= 4 })
+	s := status()
+	if s.Synced || s.LastErr == nil || !s.LastPush.IsZero() {
+		t.Errorf("Status after failed pushes: %+v", s)
+	}
+	if retry := s.NextRetry.Sub(clock.Now()); retry != expectedBackoff(s.Failures) {
+		t.Errorf("Retrying in %s after %d failures, expected %s", retry, s.Failures, expectedBackoff(s.Failures))
+	}
+
+	err := ipfs_cmds.ServePush(ctx, b.Context, ipfs_cmds.ServePushOptions{
+		AcceptFrom: []peer.ID{a.IpfsNode.Identity},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a successful push", func() bool { return status().Synced })
+	s = status()
+	if s.Failures != 0 || s.LastErr != nil || !s.NextRetry.IsZero() || !s.LastPush.Equal(clock.Now()) || s.Blocks != 1 {
+		t.Errorf("Status after a successful push: %+v", s)
+	}
+	if !hasLabelledPin(t, b, first) {
+		t.Error("Pushed pin is not pinned by the push node")
+	}
+
+	// Publishing triggers a push, the check period is too long to wait for
+	clock.Add(time.Minute)
+	pushed := clock.Now()
+	root := addData(t, a, "root", false)
+	if err := a.PublishRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the root to be pushed", func() bool { return status().LastPush.Equal(pushed) })
+	if !hasLabelledPin(t, b, root) {
+		t.Error("Pushed root is not pinned by the push node")
+	}
+
+	// Failures after a success start over from the minimum backoff
+	b.IpfsNode.PeerHost.RemoveStreamHandler(ipfs_cmds.PushProtocol)
+	clock.Add(time.Minute)
+	if _, err := a.PinWithTTL(ctx, addData(t, a, "ttl", false), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a failed push", func() bool { return status().Failures > 0 })
+	s = status()
+	if s.Synced || s.LastErr == nil || !s.LastPush.Equal(pushed) {
+		t.Errorf("Status after a failed push: %+v", s)
+	}
+	if retry := s.NextRetry.Sub(clock.Now()); retry != expectedBackoff(s.Failures) {
+		t.Errorf("Retrying in %s after %d failures, expected %s", retry, s.Failures, expectedBackoff(s.Failures))
+	}
+}
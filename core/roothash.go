@@ -0,0 +1,176 @@
+package ipfs_core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/namesys"
+	"github.com/ipfs/go-ipfs/thirdparty/ds-help"
+
+	namepb "github.com/ipfs/go-ipfs/namesys/pb"
+	ipath "github.com/ipfs/go-ipfs/path"
+
+	"gx/ipfs/QmVSase1JP7cq9QkPT46oNwdp9pT6kBkG3oqS14y3QcZjG/go-datastore"
+	"gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+
+	recpb "gx/ipfs/QmbxkgUceEcuSZ4ZdBA3x74VUDSSYjHYmmeEqkjxbtZ6Jg/go-libp2p-record/pb"
+
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
+)
+
+// Prefix of the resolved records kept by namesys when UsePersistentCache is set
+const ipnsPersistentCachePrefix = "IPNSPERSISENTCACHE_"
+
+var errNoIpnsRecord = errors.New("no IPNS record")
+
+// Reads the root hash from the self IPNS record in the datastore
+func rootFromIpnsRecord(nd *core.IpfsNode) (ipath.Path, error) {
+	_, ipnskey := namesys.IpnsKeysForID(nd.Identity)
+	ival, err := nd.Repo.Datastore().Get(dshelp.NewKeyFromBinary([]byte(ipnskey)))
+	if err == datastore.ErrNotFound {
+		return "", errNoIpnsRecord
+	} else if err != nil {
+		return "", err
+	}
+	val, ok := ival.([]byte)
+	if !ok {
+		return "", errors.New("IPNS record is not []byte")
+	}
+	dhtrec := new(recpb.Record)
+	if err := proto.Unmarshal(val, dhtrec); err != nil {
+		return "", fmt.Errorf("corrupt DHT record: %s", err)
+	}
+	return rootFromIpnsEntry(nd, dhtrec.GetValue())
+}
+
+// Reads the root hash from the persistent name cache of namesys
+func rootFromPersistentCache(nd *core.IpfsNode) (ipath.Path, error) {
+	ival, err := nd.Repo.Datastore().Get(datastore.NewKey(ipnsPersistentCachePrefix + nd.Identity.Pretty()))
+	if err == datastore.ErrNotFound {
+		return "", errNoIpnsRecord
+	} else if err != nil {
+		return "", err
+	}
+	val, ok := ival.([]byte)
+	if !ok {
+		return "", errors.New("cached IPNS record is not []byte")
+	}
+	return rootFromIpnsEntry(nd, val)
+}
+
+// Checks an IPNS entry is signed by our key and holds a valid path.
+// Expired entries are accepted, the republisher refreshes them.
+func rootFromIpnsEntry(nd *core.IpfsNode, data []byte) (ipath.Path, error) {
+	entry := new(namepb.IpnsEntry)
+	if err := proto.Unmarshal(data, entry); err != nil {
+		return "", fmt.Errorf("corrupt IPNS entry: %s", err)
+	}
+	sigData := bytes.Join([][]byte{
+		entry.Value,
+		entry.Validity,
+		[]byte(fmt.Sprint(entry.GetValidityType())),
+	}, []byte{})
+	if ok, err := nd.PrivateKey.GetPublic().Verify(sigData, entry.GetSignature()); err != nil || !ok {
+		return "", errors.New("IPNS entry is not signed by our key")
+	}
+	return ipath.ParsePath(string(entry.GetValue()))
+}
+
+// loadRootHash returns the root hash published at our peer ID.
+// When the self IPNS record is missing or invalid it is recovered from the
+// persistent name cache, or as a last resort the keyspace is initialized
+// again with an empty directory.
+func loadRootHash(ctx context.Context, nd *core.IpfsNode) (string, error) {
+	p, err := rootFromIpnsRecord(nd)
+	if err == nil {
+		return p.String(), nil
+	}
+	reason := err
+	if err != errNoIpnsRecord {
+		// The publisher reads the old record to sequence the new one, drop it
+		_, ipnskey := namesys.IpnsKeysForID(nd.Identity)
+		if err := nd.Repo.Datastore().Delete(dshelp.NewKeyFromBinary([]byte(ipnskey))); err != nil {
+			return "", err
+		}
+	}
+
+	p, err = rootFromPersistentCache(nd)
+	if err == nil {
+		log_start.Warningf("Self IPNS record unusable: peer=%s reason=%q recovery=persistent-cache root=%s", nd.Identity.Pretty(), reason, p)
+		if err := ipfs_cmds.OfflinePublisher(nd, nd.PrivateKey).Publish(ctx, nd.PrivateKey, p); err != nil {
+			return "", err
+		}
+		return p.String(), nil
+	}
+
+	log_start.Warningf("Self IPNS record unusable: peer=%s reason=%q recovery=initialize-keyspace cache=%q", nd.Identity.Pretty(), reason, err)
+	if err := namesys.InitializeKeyspace(ctx, nd.DAG, ipfs_cmds.OfflinePublisher(nd, nd.PrivateKey), nd.Pinning, nd.PrivateKey); err != nil {
+		return "", err
+	}
+	p, err = rootFromIpnsRecord(nd)
+	if err != nil {
+		return "", err
+	}
+	return p.String(), nil
+}
+
+// Root returns the root hash published at our peer ID
+func (n *SaturnNode) Root() string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.RootHash
+}
+
+// PublishRoot publishes hash at our peer ID and makes it the root hash.
+// After RotateIdentity it is published at the new peer ID instead.
+func (n *SaturnNode) PublishRoot(hash string) error {
+	n.lock.Lock()
+	rotatedKey := n.rotatedKey
+	n.lock.Unlock()
+
+	var val string
+	if rotatedKey != nil {
+		p, err := ipath.ParsePath("/ipfs/" + hash)
+		if err != nil {
+			return err
+		}
+		if err := ipfs_cmds.OfflinePublisher(n.IpfsNode, rotatedKey).Publish(n.ctx, rotatedKey, p); err != nil {
+			return err
+		}
+		val = p.String()
+	} else {
+		var err error
+		if val, err = ipfs_cmds.Publish(n.ctx, n.Context, hash); err != nil {
+			return err
+		}
+	}
+	n.lock.Lock()
+	n.RootHash = val
+	n.lock.Unlock()
+	n.TriggerPush()
+	return nil
+}
+
+// SyncRootHash reloads the root hash from the self IPNS record, for use
+// after publishing with ipfs_cmds directly. It fails after RotateIdentity,
+// when the self record holds the forwarding document.
+func (n *SaturnNode) SyncRootHash() error {
+	n.lock.Lock()
+	rotated := n.rotatedKey != nil
+	n.lock.Unlock()
+	if rotated {
+		return errIdentityRotated
+	}
+	p, err := rootFromIpnsRecord(n.IpfsNode)
+	if err != nil {
+		return err
+	}
+	n.lock.Lock()
+	n.RootHash = p.String()
+	n.lock.Unlock()
+	n.TriggerPush()
+	return nil
+}
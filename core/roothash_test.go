@@ -0,0 +1,113 @@
+package ipfs_core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/namesys"
+	"github.com/ipfs/go-ipfs/thirdparty/ds-help"
+
+	ipath "github.com/ipfs/go-ipfs/path"
+
+	"gx/ipfs/QmVSase1JP7cq9QkPT46oNwdp9pT6kBkG3oqS14y3QcZjG/go-datastore"
+	"gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
+)
+
+const emptyDirPath = "/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"
+
+// Returns an offline IpfsNode without a repo on disk
+func mockIpfsNode(t *testing.T) *core.IpfsNode {
+	cctx, err := ipfs_cmds.MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := cctx.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nd.Close() })
+	return nd
+}
+
+// Writes an IPNS entry of p signed by sk to the persistent name cache
+func putCachedRoot(t *testing.T, nd *core.IpfsNode, sk libp2p.PrivKey, p string) {
+	entry, err := namesys.CreateRoutingEntryData(sk, ipath.Path(p), 1, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := datastore.NewKey(ipnsPersistentCachePrefix + nd.Identity.Pretty())
+	if err := nd.Repo.Datastore().Put(key, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Overwrites the self IPNS record with garbage
+func corruptIpnsRecord(t *testing.T, nd *core.IpfsNode) {
+	_, ipnskey := namesys.IpnsKeysForID(nd.Identity)
+	if err := nd.Repo.Datastore().Put(dshelp.NewKeyFromBinary([]byte(ipnskey)), []byte("garbage")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadRootHashRecovery(t *testing.T) {
+	ctx := context.Background()
+	cached := "/ipfs/QmWATWQ7fVPP2EFGu71UkfnqhYXDYH566qy47CnJDgvs8u"
+	published := "/ipfs/QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+
+	// Without a record nor a cache entry the keyspace is initialized
+	nd := mockIpfsNode(t)
+	root, err := loadRootHash(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != emptyDirPath {
+		t.Errorf("New keyspace has root %s, expected the empty directory", root)
+	}
+
+	// A valid IPNS record wins over the cache
+	if err := ipfs_cmds.OfflinePublisher(nd, nd.PrivateKey).Publish(ctx, nd.PrivateKey, ipath.Path(published)); err != nil {
+		t.Fatal(err)
+	}
+	putCachedRoot(t, nd, nd.PrivateKey, cached)
+	if root, err = loadRootHash(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	if root != published {
+		t.Errorf("Root is %s, expected the IPNS record %s", root, published)
+	}
+
+	// A corrupt record is recovered from the cache and republished
+	corruptIpnsRecord(t, nd)
+	if root, err = loadRootHash(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	if root != cached {
+		t.Errorf("Root is %s, expected the cached %s", root, cached)
+	}
+	if p, err := rootFromIpnsRecord(nd); err != nil || p.String() != cached {
+		t.Errorf("Recovered root was not republished: %s %v", p, err)
+	}
+
+	// A cache entry signed by another key is not trusted
+	corruptIpnsRecord(t, nd)
+	other, _, err := libp2p.GenerateKeyPair(libp2p.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putCachedRoot(t, nd, other, cached)
+	if root, err = loadRootHash(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	if root != emptyDirPath {
+		t.Errorf("Root is %s, expected a new keyspace", root)
+	}
+}
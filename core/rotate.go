@@ -0,0 +1,151 @@
+package ipfs_core
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"github.com/ipfs/go-ipfs/repo/config"
+
+	ipath "github.com/ipfs/go-ipfs/path"
+
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
+)
+
+// Prefix of the keystore names of retired identity keys
+const retiredKeyPrefix = "retired-"
+
+var errIdentityRotated = errors.New("The identity was rotated, restart the node to use the new one")
+
+// Rotation is the outcome of RotateIdentity
+type Rotation struct {
+	OldPeerID  string
+	NewPeerID  string
+	Forwarding string // hash of the forwarding document
+}
+
+// RotateIdentity moves the node to a new identity key of the given type.
+// The current root hash is published under the new peer ID and the old
+// peer ID is pointed at a forwarding document signed by both keys, which
+// ipfs_cmds.ResolveFollow follows. The old key is kept in the keystore so
+// that its final record keeps being republished.
+// The new identity is written to the repo config and is used from the next
+// Start; stop the node and start a new one to switch to it. Until then
+// PublishRoot publishes under the new key, which leaves the forwarding
+// record of the old peer ID alone, and the identity can't be rotated again.
+func (n *SaturnNode) RotateIdentity(keyType, bits int) (*Rotation, error) {
+	n.lock.Lock()
+	started, stopped, rotated := n.started, n.stopped, n.rotatedKey != nil
+	n.lock.Unlock()
+	if !started {
+		return nil, errNodeNotStarted
+	}
+	if stopped {
+		return nil, errNodeStopped
+	}
+	if rotated {
+		return nil, errIdentityRotated
+	}
+	nd := n.IpfsNode
+
+	o := n.opts
+	o.KeyType, o.KeyBits, o.Seed, o.IdentityKey = keyType, bits, nil, nil
+	ident, err := newIdentity(o)
+	if err != nil {
+		return nil, err
+	}
+	skbytes, err := base64.StdEncoding.DecodeString(ident.PrivKey)
+	if err != nil {
+		return nil, err
+	}
+	newKey, err := libp2p.UnmarshalPrivateKey(skbytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fwd, err := ipfs_cmds.NewForwarding(nd.PrivateKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+	fwdHash, err := ipfs_cmds.AddForwarding(n.ctx, n.Context, fwd)
+	if err != nil {
+		return nil, err
+	}
+
+	// From here on root changes are published under the new key
+	n.lock.Lock()
+	if n.rotatedKey != nil {
+		n.lock.Unlock()
+		return nil, errIdentityRotated
+	}
+	n.rotatedKey = newKey
+	root := n.RootHash
+	n.lock.Unlock()
+
+	// Carry the content over to the new peer ID. This only writes to the
+	// datastore, the record is pushed to the network once the new identity
+	// is in use.
+	if err := ipfs_cmds.OfflinePublisher(nd, newKey).Publish(n.ctx, newKey, ipath.FromString(root)); err != nil {
+		n.cancelRotation()
+		return nil, err
+	}
+
+	// Persist the new identity and keep the old key around for the
+	// republisher before the old peer ID is pointed away
+	ks := nd.Repo.Keystore()
+	retired := retiredKeyPrefix + fwd.OldPeerID
+	if err := ks.Put(retired, nd.PrivateKey); err != nil {
+		n.cancelRotation()
+		return nil, err
+	}
+	if err := n.writeIdentity(ident); err != nil {
+		if derr := ks.Delete(retired); derr != nil {
+			log_repo.Error(derr)
+		}
+		n.cancelRotation()
+		return nil, err
+	}
+
+	// The final record of the old peer ID
+	if _, err := ipfs_cmds.Publish(n.ctx, n.Context, fwdHash); err != nil {
+		return nil, err
+	}
+	n.TriggerPush()
+	log_repo.Warningf("Identity rotated from %s to %s, forwarding document %s. Restart the node to use the new identity.", fwd.OldPeerID, fwd.NewPeerID, fwdHash)
+
+	return &Rotation{
+		OldPeerID:  fwd.OldPeerID,
+		NewPeerID:  fwd.NewPeerID,
+		Forwarding: fwdHash,
+	}, nil
+}
+
+// Publishes root changes under the current key again after a rotation
+// failed before the new identity was written
+func (n *SaturnNode) cancelRotation() {
+	n.lock.Lock()
+	n.rotatedKey = nil
+	n.lock.Unlock()
+}
+
+// writeIdentity replaces the identity in the repo config of the started
+// node. The key is encrypted if the current one is.
+func (n *SaturnNode) writeIdentity(ident config.Identity) error {
+	r := n.IpfsNode.Repo
+	if ur, ok := r.(*unlockedRepo); ok {
+		r = ur.Repo
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	if ipfs_cmds.IdentityEncrypted(cfg.Identity) {
+		if ident, err = ipfs_cmds.EncryptIdentity(ident, n.opts.Passphrase); err != nil {
+			return err
+		}
+	}
+	c := *cfg
+	c.Identity = ident
+	return r.SetConfig(&c)
+}
@@ -0,0 +1,104 @@
+package ipfs_core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/namesys"
+	"github.com/ipfs/go-ipfs/thirdparty/ds-help"
+
+	namepb "github.com/ipfs/go-ipfs/namesys/pb"
+
+	"gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	"gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+
+	recpb "gx/ipfs/QmbxkgUceEcuSZ4ZdBA3x74VUDSSYjHYmmeEqkjxbtZ6Jg/go-libp2p-record/pb"
+)
+
+// Returns the path of the IPNS record of id in the datastore of nd
+func ipnsValue(t *testing.T, nd *core.IpfsNode, id string) string {
+	pid, err := peer.IDB58Decode(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ipnskey := namesys.IpnsKeysForID(pid)
+	val, err := nd.Repo.Datastore().Get(dshelp.NewKeyFromBinary([]byte(ipnskey)))
+	if err != nil {
+		t.Fatalf("No IPNS record for %s: %s", id, err)
+	}
+	rec := new(recpb.Record)
+	if err := proto.Unmarshal(val.([]byte), rec); err != nil {
+		t.Fatal(err)
+	}
+	entry := new(namepb.IpnsEntry)
+	if err := proto.Unmarshal(rec.GetValue(), entry); err != nil {
+		t.Fatal(err)
+	}
+	return string(entry.GetValue())
+}
+
+func TestRotateIdentity(t *testing.T) {
+	opts := testOptions(t)
+	n := startTestNode(t, opts...)
+	connectTestNodes(t, n, startTestNode(t, testOptions(t)...))
+	oldID := n.IpfsNode.Identity.Pretty()
+
+	root := addData(t, n, "root", true)
+	if err := n.PublishRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	rot, err := n.RotateIdentity(libp2p.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rot.OldPeerID != oldID || rot.NewPeerID == oldID {
+		t.Errorf("Unexpected rotation %+v", rot)
+	}
+	if _, err := n.RotateIdentity(libp2p.Ed25519, 0); err != errIdentityRotated {
+		t.Errorf("Second rotation returned %v, expected %v", err, errIdentityRotated)
+	}
+
+	// The old peer ID forwards, the new one holds the root
+	nd := n.IpfsNode
+	if v := ipnsValue(t, nd, oldID); v != "/ipfs/"+rot.Forwarding {
+		t.Errorf("Old peer ID points at %s, expected the forwarding document", v)
+	}
+	if v := ipnsValue(t, nd, rot.NewPeerID); v != "/ipfs/"+root {
+		t.Errorf("New peer ID points at %s, expected the root", v)
+	}
+	if n.Root() != "/ipfs/"+root {
+		t.Errorf("Root changed to %s by the rotation", n.Root())
+	}
+	if has, err := nd.Repo.Keystore().Has(retiredKeyPrefix + oldID); err != nil || !has {
+		t.Errorf("Old key is not kept: %v", err)
+	}
+
+	// Root changes go to the new peer ID and leave the forwarding alone
+	root = addData(t, n, "new root", true)
+	if err := n.PublishRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	if v := ipnsValue(t, nd, oldID); v != "/ipfs/"+rot.Forwarding {
+		t.Errorf("Publishing overwrote the forwarding record with %s", v)
+	}
+	if v := ipnsValue(t, nd, rot.NewPeerID); v != "/ipfs/"+root {
+		t.Errorf("New peer ID points at %s, expected the new root", v)
+	}
+	if err := n.SyncRootHash(); err != errIdentityRotated {
+		t.Errorf("SyncRootHash returned %v, expected %v", err, errIdentityRotated)
+	}
+
+	// The new identity and root are used after a restart
+	if err := n.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	n = startTestNode(t, opts...)
+	if id := n.IpfsNode.Identity.Pretty(); id != rot.NewPeerID {
+		t.Errorf("Restarted as %s, expected %s", id, rot.NewPeerID)
+	}
+	if n.Root() != "/ipfs/"+root {
+		t.Errorf("Root after the restart is %s, expected %s", n.Root(), "/ipfs/"+root)
+	}
+}
@@ -2,28 +2,28 @@ package ipfs_core
 
 import (
 	"context"
+	"errors"
 	"sort"
 
 	"github.com/ipfs/go-ipfs/commands"
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/corerepo"
 	"github.com/ipfs/go-ipfs/namesys"
 	"github.com/ipfs/go-ipfs/repo/config"
 	"github.com/ipfs/go-ipfs/repo/fsrepo"
-	"github.com/ipfs/go-ipfs/thirdparty/ds-help"
 	"github.com/op/go-logging"
 
-	namepb "github.com/ipfs/go-ipfs/namesys/pb"
-	ipath "github.com/ipfs/go-ipfs/path"
 	ipfsrepo "github.com/ipfs/go-ipfs/repo"
 
 	"gx/ipfs/QmPR2JzfKd9poHx9XBhzoFeBBC31ZM3W5iUPKJZWyaoZZm/go-libp2p-routing"
+	pstore "gx/ipfs/QmPgDWmTmuzvP7QE5zwo1TmjbJme9pmZHNujB2453jkCTr/go-libp2p-peerstore"
 	"gx/ipfs/QmT7PnPxYkeKPCG8pAnucfcjrXc15Q7FgvFv7YC24EPrw8/go-libp2p-kad-dht"
-	"gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
 
 	dhtutil "gx/ipfs/QmUCS9EnqNq1kCnJds2eLDypBiS21aSiCf1MVzSUVB9TGA/go-libp2p-kad-dht/util"
 	"gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
 	p2phost "gx/ipfs/QmaSxYRuMq4pkpBBG2CYaRrPx2z7NmMVEs34b9g61biQA6/go-libp2p-host"
-	recpb "gx/ipfs/QmbxkgUceEcuSZ4ZdBA3x74VUDSSYjHYmmeEqkjxbtZ6Jg/go-libp2p-record/pb"
+
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
 )
 
 var log_start = logging.MustGetLogger("start")
@@ -50,36 +50,106 @@ func constructDHTRouting(ctx context.Context, host p2phost.Host, dstore ipfsrepo
 	return dhtRouting, nil
 }
 
-func Start(repoPath string) (e error) {
+// Start creates a node from opts and brings it online. The repo must have
+// been created by Init.
+func Start(opts ...Option) (*SaturnNode, error) {
+	o, err := NewOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	n, err := NewSaturnNode(o)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.Start(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Start brings the node online using the repo created by Init.
+// The options of the node which are set are written to the repo config first.
+func (n *SaturnNode) Start() (e error) {
 	//=========================================== Start ===========================================
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.started {
+		return errors.New("Saturn node is already started")
+	}
+	repoPath := n.RepoPath
+
+	// Bring the repo up to date before opening it
+	if err := migrateRepo(repoPath); err != nil {
+		log_start.Error(err)
+		return err
+	}
+
 	// IPFS node setup
 	r, err := fsrepo.Open(repoPath)
 	if err != nil {
 		log_start.Error(err)
 		return err
 	}
-	cctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	cfg, err := r.Config()
 	if err != nil {
 		log_start.Error(err)
+		r.Close()
+		return err
+	}
+	changed, err := n.opts.applyConfig(cfg, false)
+	if err == nil && changed {
+		err = r.SetConfig(cfg)
+	}
+	if err != nil {
+		log_start.Error(err)
+		r.Close()
+		return err
+	}
+	sharing, err := readDataSharing(r)
+	if err != nil {
+		log_start.Error(err)
+		r.Close()
+		return err
+	}
+	pushTo, err := parsePushNodes(sharing.PushTo)
+	if err != nil {
+		log_start.Error(err)
+		r.Close()
+		return err
+	}
+	acceptFrom, err := parsePushNodes(sharing.AcceptFrom)
+	if err != nil {
+		log_start.Error(err)
+		r.Close()
 		return err
 	}
 
+	// Unlock the identity key if it is encrypted
+	ur, err := unlockRepo(r, repoPath, n.opts.Passphrase)
+	if err != nil {
+		log_start.Error(err)
+		r.Close()
+		return err
+	}
+
+	// The node context is owned by the SaturnNode and is only cancelled by Stop
+	cctx, cancel := context.WithCancel(context.Background())
+
 	ncfg := &core.BuildCfg{
-		Repo:   r,
+		Repo:   ur,
 		Online: true,
 		ExtraOpts: map[string]bool{
 			"mplex": true,
 		},
 		DNSResolver: namesys.NewDNSResolver(),
 		Routing:     DHTOption,
+		Host:        ipfs_cmds.DirectionHostOption,
 	}
 
 	nd, err := core.NewNode(cctx, ncfg)
 	if err != nil {
 		log_start.Error(err)
+		cancel()
 		return err
 	}
 	nd.SetLocal(false)
@@ -95,6 +165,8 @@ func Start(repoPath string) (e error) {
 	}
 
 	// Set IPNS query size
+	// NOTE: these are package level settings of the vendored dht and namesys,
+	// so they are shared by all the nodes of the process.
 	querySize := cfg.Ipns.QuerySize
 	if querySize <= 20 && querySize > 0 {
 		dhtutil.QuerySize = int(querySize)
@@ -107,39 +179,59 @@ func Start(repoPath string) (e error) {
 	printSwarmAddrs(nd)
 
 	// Get current directory root hash
-	_, ipnskey := namesys.IpnsKeysForID(nd.Identity)
-	ival, err := nd.Repo.Datastore().Get(dshelp.NewKeyFromBinary([]byte(ipnskey)))
+	rootHash, err := loadRootHash(cctx, nd)
 	if err != nil {
 		log_start.Error(err)
+		nd.Close()
+		cancel()
 		return err
 	}
-	val := ival.([]byte)
-	dhtrec := new(recpb.Record)
-	proto.Unmarshal(val, dhtrec)
-	ipnsEntry := new(namepb.IpnsEntry)
-	proto.Unmarshal(dhtrec.GetValue(), ipnsEntry)
 
 	// Push nodes
 	var pushNodes []peer.ID
-	//for _, pnd := range dataSharing.PushTo {
-	//	p, err := peer.IDB58Decode(pnd)
-	//	if err != nil {
-	//		log_start.Error("Invalid peerID in DataSharing config")
-	//		return err
-	//	}
-	//	pushNodes = append(pushNodes, p)
-	//}
-
-	Node = &SaturnNode{
-		Context:             ctx,
-		IpfsNode:            nd,
-		RootHash:            ipath.Path(ipnsEntry.Value).String(),
-		RepoPath:            repoPath,
-		PushNodes:           pushNodes,
-		UserAgent:           USERAGENT,
-		AcceptStoreRequests: true,
-		IPNSBackupAPI:       cfg.Ipns.BackUpAPI,
+	for _, pi := range pushTo {
+		nd.Peerstore.AddAddrs(pi.ID, pi.Addrs, pstore.PermanentAddrTTL)
+		pushNodes = append(pushNodes, pi.ID)
+	}
+	if sharing.AcceptStoreRequests {
+		serve := ipfs_cmds.ServePushOptions{
+			MaxBlocks: sharing.MaxPushBlocks,
+			MaxBytes:  sharing.MaxPushBytes,
+		}
+		for _, pi := range acceptFrom {
+			serve.AcceptFrom = append(serve.AcceptFrom, pi.ID)
+		}
+		if len(serve.AcceptFrom) == 0 {
+			log_start.Warning("Store requests are accepted from nobody, DataSharing.AcceptFrom is empty")
+		}
+		if err := ipfs_cmds.ServePush(cctx, ctx, serve); err != nil {
+			log_start.Error(err)
+		}
 	}
 
+	if n.opts.PeriodicGC {
+		go func() {
+			if err := corerepo.PeriodicGC(cctx, nd); err != nil {
+				log_start.Error(err)
+			}
+		}()
+	}
+
+	if n.opts.PinSweepPeriod > 0 {
+		go n.sweepPins(cctx, ctx, n.opts.PinSweepPeriod, n.opts.PinSweepGC)
+	}
+
+	n.Context = ctx
+	n.IpfsNode = nd
+	n.RootHash = rootHash
+	n.PushNodes = pushNodes
+	n.AcceptStoreRequests = sharing.AcceptStoreRequests
+	n.IPNSBackupAPI = cfg.Ipns.BackUpAPI
+	n.ctx = cctx
+	n.cancel = cancel
+	n.started = true
+
+	n.startPush(cctx, pushTo)
+
 	return nil
 }
@@ -0,0 +1,193 @@
+package ipfs_core
+
+import (
+	"context"
+	"net"
+	"sort"
+	"time"
+
+	inet "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	manet "gx/ipfs/QmX3U3YXCQ6UYBxq2LVWF8dARS1hPUTEYLrSx654Qyxyw6/go-multiaddr-net"
+	ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+
+	dht "gx/ipfs/QmT7PnPxYkeKPCG8pAnucfcjrXc15Q7FgvFv7YC24EPrw8/go-libp2p-kad-dht"
+)
+
+// How often WaitReady checks the node status
+var readyPollInterval = time.Millisecond * 500
+
+// Reachability of the node from the rest of the network
+type Reachability string
+
+const (
+	ReachabilityUnknown Reachability = "unknown" // no usable address
+	ReachabilityPrivate Reachability = "private" // only private or loopback addresses
+	ReachabilityNAT     Reachability = "nat"     // public address known through NAT mapping or peers
+	ReachabilityPublic  Reachability = "public"  // listening on a public address
+)
+
+// ReadyCriteria are the conditions WaitReady waits for
+type ReadyCriteria struct {
+	// Minimum number of connected peers
+	MinPeers int
+
+	// At least one of the bootstrap peers in the config is connected
+	BootstrapPeer bool
+
+	// The DHT routing table is not empty
+	RoutingTable bool
+}
+
+// NodeStatus is a snapshot of the network state of a node
+type NodeStatus struct {
+	Peers            int
+	BootstrapPeers   int
+	RoutingTableSize int
+	ListenAddrs      []string
+	Reachability     Reachability
+}
+
+// Status returns the current network state of the node
+func (n *SaturnNode) Status() (NodeStatus, error) {
+	var st NodeStatus
+	n.lock.Lock()
+	started := n.started
+	n.lock.Unlock()
+	if !started {
+		return st, errNodeNotStarted
+	}
+
+	host := n.IpfsNode.PeerHost
+	network := host.Network()
+	peers := network.Peers()
+	st.Peers = len(peers)
+
+	cfg, err := n.IpfsNode.Repo.Config()
+	if err != nil {
+		return st, err
+	}
+	bootstrap, err := cfg.BootstrapPeers()
+	if err != nil {
+		return st, err
+	}
+	for _, bp := range bootstrap {
+		if network.Connectedness(bp.ID()) == inet.Connected {
+			st.BootstrapPeers++
+		}
+	}
+
+	// The routing table of the DHT is not exposed, count the connected peers
+	// which speak the DHT protocol instead, which is what it is filled with.
+	if _, ok := n.IpfsNode.Routing.(*dht.IpfsDHT); ok {
+		ps := host.Peerstore()
+		for _, p := range peers {
+			protos, err := ps.SupportsProtocols(p, string(dht.ProtocolDHT), string(dht.ProtocolDHTOld))
+			if err == nil && len(protos) > 0 {
+				st.RoutingTableSize++
+			}
+		}
+	}
+
+	listenAddrs, err := network.InterfaceListenAddresses()
+	if err != nil {
+		return st, err
+	}
+	for _, addr := range listenAddrs {
+		st.ListenAddrs = append(st.ListenAddrs, addr.String())
+	}
+	sort.Strings(st.ListenAddrs)
+	st.Reachability = reachability(listenAddrs, host.Addrs())
+
+	return st, nil
+}
+
+// WaitReady blocks until the node meets the criteria or ctx is done
+func (n *SaturnNode) WaitReady(ctx context.Context, criteria ReadyCriteria) (NodeStatus, error) {
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for {
+		st, err := n.Status()
+		if err != nil {
+			return st, err
+		}
+		if st.Peers >= criteria.MinPeers &&
+			(!criteria.BootstrapPeer || st.BootstrapPeers > 0) &&
+			(!criteria.RoutingTable || st.RoutingTableSize > 0) {
+			return st, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return st, ctx.Err()
+		case <-n.done:
+			return st, errNodeStopped
+		case <-ticker.C:
+		}
+	}
+}
+
+func reachability(listenAddrs, hostAddrs []ma.Multiaddr) Reachability {
+	for _, addr := range listenAddrs {
+		if isPublicAddr(addr) {
+			return ReachabilityPublic
+		}
+	}
+	for _, addr := range hostAddrs {
+		if isPublicAddr(addr) {
+			return ReachabilityNAT
+		}
+	}
+	if len(listenAddrs) > 0 {
+		return ReachabilityPrivate
+	}
+	return ReachabilityUnknown
+}
+
+var privateNets = parseCIDRs(
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func isPublicAddr(addr ma.Multiaddr) bool {
+	if manet.IsIPLoopback(addr) || manet.IsIPUnspecified(addr) {
+		return false
+	}
+	naddr, err := manet.ToNetAddr(addr)
+	if err != nil {
+		return false
+	}
+	var ip net.IP
+	switch a := naddr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
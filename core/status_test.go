@@ -0,0 +1,99 @@
+package ipfs_core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+)
+
+func TestStatus(t *testing.T) {
+	defer func(interval time.Duration) { readyPollInterval = interval }(readyPollInterval)
+	readyPollInterval = time.Millisecond * 10
+	ctx := context.Background()
+
+	n, err := Init(testOptions(t)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.Status(); err != errNodeNotStarted {
+		t.Errorf("Status before Start returned %v, expected %v", err, errNodeNotStarted)
+	}
+
+	a := startTestNode(t, testOptions(t)...)
+	st, err := a.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Peers != 0 || st.BootstrapPeers != 0 || st.RoutingTableSize != 0 {
+		t.Errorf("Unconnected node has peers: %+v", st)
+	}
+	var listenAddr string
+	for _, addr := range st.ListenAddrs {
+		if strings.HasPrefix(addr, "/ip4/127.0.0.1/tcp/") {
+			listenAddr = addr
+		}
+	}
+	if listenAddr == "" {
+		t.Fatalf("Not listening on loopback: %v", st.ListenAddrs)
+	}
+	if st.Reachability != ReachabilityPrivate {
+		t.Errorf("Loopback node is %s", st.Reachability)
+	}
+
+	// Criteria which are not met time out
+	wctx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	if _, err := a.WaitReady(wctx, ReadyCriteria{MinPeers: 1}); err != context.DeadlineExceeded {
+		t.Errorf("WaitReady without peers returned %v", err)
+	}
+
+	// b bootstraps from a, which connects them
+	bootstrap := fmt.Sprintf("%s/ipfs/%s", listenAddr, a.IpfsNode.Identity.Pretty())
+	b := startTestNode(t, testOptions(t, WithBootstrap(bootstrap))...)
+	wctx, cancel = context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	st, err = b.WaitReady(wctx, ReadyCriteria{MinPeers: 1, BootstrapPeer: true, RoutingTable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Peers != 1 || st.BootstrapPeers != 1 || st.RoutingTableSize != 1 {
+		t.Errorf("Unexpected status after bootstrapping %+v", st)
+	}
+
+	// Waiting ends when the node is stopped
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		a.Stop(ctx)
+	}()
+	if _, err := a.WaitReady(ctx, ReadyCriteria{MinPeers: 2}); err != errNodeStopped {
+		t.Errorf("WaitReady on a stopped node returned %v, expected %v", err, errNodeStopped)
+	}
+}
+
+func TestReachability(t *testing.T) {
+	addrs := func(s ...string) []ma.Multiaddr {
+		var l []ma.Multiaddr
+		for _, a := range s {
+			l = append(l, ma.StringCast(a))
+		}
+		return l
+	}
+	for _, c := range []struct {
+		listen, host []ma.Multiaddr
+		expected     Reachability
+	}{
+		{nil, nil, ReachabilityUnknown},
+		{addrs("/ip4/127.0.0.1/tcp/4001", "/ip4/192.168.1.2/tcp/4001"), nil, ReachabilityPrivate},
+		{addrs("/ip4/10.0.0.2/tcp/4001"), addrs("/ip4/10.0.0.2/tcp/4001", "/ip4/1.2.3.4/tcp/4001"), ReachabilityNAT},
+		{addrs("/ip4/1.2.3.4/tcp/4001"), nil, ReachabilityPublic},
+		{addrs("/ip6/fe80::1/tcp/4001", "/ip6/2001:4860::1/tcp/4001"), nil, ReachabilityPublic},
+	} {
+		if r := reachability(c.listen, c.host); r != c.expected {
+			t.Errorf("Listening on %v with host addresses %v is %s, expected %s", c.listen, c.host, r, c.expected)
+		}
+	}
+}
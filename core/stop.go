@@ -0,0 +1,108 @@
+package ipfs_core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+var log_stop = logging.MustGetLogger("stop")
+
+var errNodeStopped = errors.New("Saturn node is already stopped")
+
+// Register a function to be run when the node is stopped.
+// Hooks run in reverse order of registration, before the IpfsNode is closed.
+func (n *SaturnNode) OnShutdown(hook func() error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.shutdownHooks = append(n.shutdownHooks, hook)
+}
+
+// Done returns a channel which is closed once the node has been stopped
+func (n *SaturnNode) Done() <-chan struct{} {
+	return n.done
+}
+
+// Stop shuts the node down. It runs the registered shutdown hooks, closes
+// the IpfsNode (which flushes and closes the repo datastore and releases
+// the fsrepo lock) and finally cancels the node context.
+// If ctx expires first Stop returns its error and the shutdown carries on
+// in the background.
+func (n *SaturnNode) Stop(ctx context.Context) error {
+	n.lock.Lock()
+	if !n.started {
+		n.lock.Unlock()
+		return errNodeNotStarted
+	}
+	if n.stopped {
+		n.lock.Unlock()
+		return errNodeStopped
+	}
+	n.stopped = true
+	hooks := n.shutdownHooks
+	n.shutdownHooks = nil
+	n.lock.Unlock()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- n.shutdown(hooks)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		log_stop.Warning("Shutdown did not complete in time: ", ctx.Err())
+		return ctx.Err()
+	}
+}
+
+func (n *SaturnNode) shutdown(hooks []func() error) error {
+	defer close(n.done)
+	defer n.cancel()
+
+	var first error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i](); err != nil {
+			log_stop.Error(err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	log_stop.Info("Closing IPFS node")
+	if err := n.IpfsNode.Close(); err != nil {
+		log_stop.Error(err)
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// StopOnSignal stops the node when the process receives SIGINT or SIGTERM.
+// The shutdown is given at most timeout to finish. It returns immediately;
+// use Done to wait for the node to be stopped.
+func (n *SaturnNode) StopOnSignal(timeout time.Duration) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(sigc)
+		select {
+		case sig := <-sigc:
+			log_stop.Infof("Received %s, shutting down...", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := n.Stop(ctx); err != nil && err != errNodeStopped {
+				log_stop.Error(err)
+			}
+		case <-n.done:
+		}
+	}()
+}
@@ -0,0 +1,65 @@
+package ipfs_core
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStop(t *testing.T) {
+	ctx := context.Background()
+	opts := testOptions(t)
+	n, err := Init(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Stop(ctx); err != errNodeNotStarted {
+		t.Fatalf("Stop before Start returned %v, expected %v", err, errNodeNotStarted)
+	}
+	n = startTestNode(t, opts...)
+
+	var ran []int
+	hookErr := errors.New("hook failed")
+	n.OnShutdown(func() error {
+		ran = append(ran, 1)
+		return nil
+	})
+	n.OnShutdown(func() error {
+		ran = append(ran, 2)
+		return hookErr
+	})
+	n.OnShutdown(func() error {
+		ran = append(ran, 3)
+		return nil
+	})
+
+	select {
+	case <-n.Done():
+		t.Fatal("Done is closed before Stop")
+	default:
+	}
+	if err := n.Stop(ctx); err != hookErr {
+		t.Errorf("Stop returned %v, expected the hook error %v", err, hookErr)
+	}
+	if !reflect.DeepEqual(ran, []int{3, 2, 1}) {
+		t.Errorf("Hooks ran in order %v, expected reverse order of registration", ran)
+	}
+	select {
+	case <-n.Done():
+	default:
+		t.Error("Done is not closed after Stop")
+	}
+	if n.ctx.Err() == nil {
+		t.Error("Node context is not canceled after Stop")
+	}
+	if err := n.Stop(ctx); err != errNodeStopped {
+		t.Errorf("Second Stop returned %v, expected %v", err, errNodeStopped)
+	}
+	if len(ran) != 3 {
+		t.Errorf("Hooks ran again on the second Stop: %v", ran)
+	}
+
+	// The repo lock is released, so the repo can be opened again
+	startTestNode(t, opts...)
+}
@@ -0,0 +1,141 @@
+package ipfs_core
+
+import (
+	"path/filepath"
+
+	"github.com/ipfs/go-ipfs/keystore"
+	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/go-ipfs/repo/config"
+	"github.com/ipfs/go-ipfs/repo/fsrepo"
+
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
+)
+
+// Errors returned by Start and ChangePassphrase when the identity key is
+// encrypted and the passphrase is missing or wrong
+var (
+	ErrKeyLocked       = ipfs_cmds.ErrKeyLocked
+	ErrWrongPassphrase = ipfs_cmds.ErrWrongPassphrase
+)
+
+func keystorePath(repoPath string) string {
+	return filepath.Join(repoPath, "keystore")
+}
+
+// unlockedRepo is a repo whose identity key and keystore are encrypted on
+// disk. The config it returns holds the key in the clear so go-ipfs can
+// load it, while the encrypted key is kept when the config is written back.
+type unlockedRepo struct {
+	repo.Repo
+	ident    config.Identity
+	keystore keystore.Keystore
+}
+
+// unlockRepo decrypts the identity of r with passphrase. Repos whose
+// identity is not encrypted are returned as is.
+func unlockRepo(r repo.Repo, repoPath string, passphrase []byte) (repo.Repo, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	if !ipfs_cmds.IdentityEncrypted(cfg.Identity) {
+		if len(passphrase) > 0 {
+			log_start.Warning("A passphrase was given but the identity key is not encrypted, use ChangePassphrase to encrypt it")
+		}
+		return r, nil
+	}
+
+	ident, err := ipfs_cmds.DecryptIdentity(cfg.Identity, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := ipfs_cmds.NewEncryptedKeystore(keystorePath(repoPath), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &unlockedRepo{Repo: r, ident: ident, keystore: ks}, nil
+}
+
+func (r *unlockedRepo) Config() (*config.Config, error) {
+	cfg, err := r.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	c := *cfg
+	c.Identity = r.ident
+	return &c, nil
+}
+
+func (r *unlockedRepo) SetConfig(updated *config.Config) error {
+	cfg, err := r.Repo.Config()
+	if err != nil {
+		return err
+	}
+	c := *updated
+	c.Identity = cfg.Identity
+	return r.Repo.SetConfig(&c)
+}
+
+func (r *unlockedRepo) Keystore() keystore.Keystore {
+	return r.keystore
+}
+
+// ChangePassphrase re-encrypts the identity key and the keystore of the repo
+// at repoPath. An empty oldPassphrase means the keys are not encrypted yet
+// and an empty newPassphrase stores them in the clear.
+// The repo must not be in use by a started node.
+func ChangePassphrase(repoPath string, oldPassphrase, newPassphrase []byte) error {
+	r, err := fsrepo.Open(repoPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	ident, err := ipfs_cmds.DecryptIdentity(cfg.Identity, oldPassphrase)
+	if err != nil {
+		return err
+	}
+	if len(newPassphrase) > 0 {
+		if ident, err = ipfs_cmds.EncryptIdentity(ident, newPassphrase); err != nil {
+			return err
+		}
+	}
+
+	ks, err := ipfs_cmds.NewEncryptedKeystore(keystorePath(repoPath), oldPassphrase)
+	if err != nil {
+		return err
+	}
+	if err := ks.Rekey(newPassphrase); err != nil {
+		return err
+	}
+
+	// Keep both keys under the same passphrase if the config can't be written
+	c := *cfg
+	c.Identity = ident
+	if err := r.SetConfig(&c); err != nil {
+		if rerr := ks.Rekey(oldPassphrase); rerr != nil {
+			log_repo.Errorf("Restoring the passphrase of the keystore failed: %s", rerr)
+		}
+		return err
+	}
+	log_repo.Infof("Changed the passphrase of the keys in %s", repoPath)
+	return nil
+}
+
+// ExportIdentityKey returns the private key of the node in format, unlocked
+// with the passphrase of the node if it is encrypted
+func (n *SaturnNode) ExportIdentityKey(format ipfs_cmds.KeyFormat) ([]byte, error) {
+	cfg, err := fsrepo.ConfigAt(n.RepoPath)
+	if err != nil {
+		return nil, err
+	}
+	ident, err := ipfs_cmds.DecryptIdentity(cfg.Identity, n.opts.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return ipfs_cmds.ExportIdentityKey(ident, format)
+}
@@ -2,8 +2,12 @@ package ipfs_core
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/ipfs/go-ipfs/repo/fsrepo"
 )
 
 func CheckWriteable(dir string) error {
@@ -33,3 +37,67 @@ func CheckWriteable(dir string) error {
 
 	return err
 }
+
+// Moves the repo at dir to a timestamped backup directory next to it and
+// returns the path of the backup.
+func backupRepo(dir string) (string, error) {
+	locked, err := fsrepo.LockedByOtherProcess(dir)
+	if err != nil {
+		return "", err
+	}
+	if locked {
+		return "", fmt.Errorf("Repo %s is in use by another process", dir)
+	}
+
+	dir = filepath.Clean(dir)
+	backup := fmt.Sprintf("%s.backup-%s", dir, time.Now().Format("20060102-150405"))
+	if _, err := os.Stat(backup); err == nil {
+		return "", fmt.Errorf("Backup directory %s already exists", backup)
+	}
+	if err := os.Rename(dir, backup); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// Recursively copies the directory src to dst, which must not exist
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			// Lock files, sockets and the like are not part of the repo data
+			return nil
+		}
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
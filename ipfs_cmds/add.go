@@ -1,103 +1,274 @@
 package ipfs_cmds
 
 import (
-	"errors"
-	"github.com/ipfs/go-ipfs/commands"
-	"github.com/ipfs/go-ipfs/core/coreunix"
+	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"os"
-	"path"
-	"strconv"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/blockservice"
+	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/commands/files"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+	"github.com/ipfs/go-ipfs/importer/chunk"
+	"github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/mfs"
+	"github.com/ipfs/go-ipfs/unixfs"
+
+	dagtest "github.com/ipfs/go-ipfs/merkledag/test"
+
+	cid "gx/ipfs/QmNp85zy9RLrQ5oQD4hPyS39ezrrXpcaa7R4Y9kxdWQLLQ/go-cid"
+	mh "gx/ipfs/QmU9a9NV9RdPNwZQDYd5uKsm6N6LJLSvLbywDDYFbaaC6P/go-multihash"
 )
 
-var addErr = errors.New(`Add directory failed`)
+// AddOptions controls how files are imported. The zero value adds CIDv0
+// with sha2-256 and the default chunker, like ipfs add, but does not pin;
+// DefaultAddOptions are what AddFile and AddDirectory use.
+type AddOptions struct {
+	// "size-<bytes>", "rabin", "rabin-<avg>" or "rabin-<min>-<avg>-<max>",
+	// 256KiB blocks if empty
+	Chunker string
+	// Store file data in raw blocks instead of wrapping it in unixfs nodes
+	RawLeaves bool
+	// Use the trickle layout instead of the balanced one, for streaming
+	Trickle bool
+	// Multihash name like "sha2-256" or "blake2b-256", sha2-256 if empty
+	HashFunc string
+	// 0 or 1. CIDv0 only supports sha2-256.
+	CidVersion int
+	// Add a directory around what is added and return its hash
+	Wrap bool
+	// Name of a reader inside the wrapping directory, its hash by default
+	Name string
+	// Pin the added root recursively
+	Pin bool
+	// Only compute the hash, nothing is stored or pinned
+	OnlyHash bool
+	// Called with the number of bytes read so far, readers only
+	Progress func(read int64)
+}
 
-// Resursively add a directory to IPFS and return the root hash
-func AddDirectory(ctx commands.Context, fpath string) (rootHash string, err error) {
-	_, root := path.Split(fpath)
-	args := []string{"add", "-r", "--cid-version", strconv.Itoa(1), fpath}
-	req, cmd, err := NewRequest(ctx, args)
+// DefaultAddOptions returns the options AddFile and AddDirectory use:
+// pinned CIDv1 with raw leaves
+func DefaultAddOptions() AddOptions {
+	return AddOptions{CidVersion: 1, RawLeaves: true, Pin: true}
+}
+
+// Returns the CID prefix of opts, after checking they are consistent
+func (opts AddOptions) prefix() (*cid.Prefix, error) {
+	if opts.CidVersion != 0 && opts.CidVersion != 1 {
+		return nil, fmt.Errorf("Unknown CID version %d", opts.CidVersion)
+	}
+	hashFunc := strings.ToLower(opts.HashFunc)
+	if hashFunc == "" {
+		hashFunc = "sha2-256"
+	}
+	mhType, ok := mh.Names[hashFunc]
+	if !ok {
+		return nil, fmt.Errorf("Unrecognized hash function: %s", hashFunc)
+	}
+	if opts.CidVersion == 0 && mhType != mh.SHA2_256 {
+		return nil, fmt.Errorf("CIDv0 only supports sha2-256, not %s", hashFunc)
+	}
+	if _, err := chunk.FromString(bytes.NewReader(nil), opts.Chunker); err != nil {
+		return nil, err
+	}
+
+	prefix, err := merkledag.PrefixForCidVersion(opts.CidVersion)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	res := commands.NewResponse(req)
-	cmd.PreRun(req)
-	cmd.Run(req, res)
-	for r := range res.Output().(<-chan interface{}) {
-		if r.(*coreunix.AddedObject).Name == root {
-			rootHash = r.(*coreunix.AddedObject).Hash
-		}
+	prefix.MhType = mhType
+	prefix.MhLength = -1
+	return &prefix, nil
+}
+
+// addPath adds the file or directory at fpath and returns the root hash
+func addPath(ctx context.Context, cctx commands.Context, fpath string, recursive bool, opts AddOptions) (_ string, err error) {
+	defer wrapErr(&err, "add", fpath)
+	fpath = filepath.Clean(fpath)
+	stat, err := os.Lstat(fpath)
+	if err != nil {
+		return "", err
 	}
-	cmd.PostRun(req, res)
-	if res.Error() != nil {
-		return "", res.Error()
+	if stat.IsDir() && !recursive {
+		return "", fmt.Errorf("%s is a directory, use the '-r' flag to specify directories", fpath)
 	}
-	if rootHash == "" {
-		return "", addErr
+	file, err := files.NewSerialFile(filepath.Base(fpath), fpath, false, stat)
+	if err != nil {
+		return "", err
 	}
-	return rootHash, nil
+	return addFiles(ctx, cctx, withContext(ctx, file), opts)
 }
 
-func AddFile(ctx commands.Context, fpath string) (string, error) {
-	args := []string{"add", "--cid-version", strconv.Itoa(1), fpath}
-	req, cmd, err := NewRequest(ctx, args)
+// addFiles adds file, which is read as it is imported, and returns the
+// root hash
+func addFiles(ctx context.Context, cctx commands.Context, file files.File, opts AddOptions) (string, error) {
+	prefix, err := opts.prefix()
+	if err != nil {
+		return "", err
+	}
+	nd, err := cctx.GetNode()
 	if err != nil {
 		return "", err
 	}
-	res := commands.NewResponse(req)
-	cmd.PreRun(req)
-	cmd.Run(req, res)
-	var fileHash string
-	for r := range res.Output().(<-chan interface{}) {
-		fileHash = r.(*coreunix.AddedObject).Hash
+
+	if opts.OnlyHash {
+		nilnode, err := core.NewNode(ctx, &core.BuildCfg{NilRepo: true})
+		if err != nil {
+			return "", err
+		}
+		defer nilnode.Close()
+		nd = nilnode
 	}
-	cmd.PostRun(req, res)
-	if res.Error() != nil {
-		return "", res.Error()
+
+	bs := blockstore.NewGCBlockstore(nd.BaseBlocks, nd.GCLocker)
+	dserv := merkledag.NewDAGService(blockservice.New(bs, nd.Exchange))
+
+	fileAdder, err := coreunix.NewAdder(ctx, nd.Pinning, nd.Blockstore, dserv)
+	if err != nil {
+		return "", err
 	}
-	if fileHash == "" {
-		return "", addErr
+	fileAdder.Chunker = opts.Chunker
+	fileAdder.RawLeaves = opts.RawLeaves
+	fileAdder.Trickle = opts.Trickle
+	fileAdder.Wrap = opts.Wrap
+	fileAdder.Pin = opts.Pin && !opts.OnlyHash
+	fileAdder.Prefix = prefix
+
+	if opts.OnlyHash {
+		rnode := unixfs.EmptyDirNode()
+		rnode.SetPrefix(prefix)
+		mr, err := mfs.NewRoot(ctx, dagtest.Mock(), rnode, nil)
+		if err != nil {
+			return "", err
+		}
+		mr.Prefix = prefix
+		fileAdder.SetMfsRoot(mr)
 	}
-	return fileHash, nil
-}
 
-func GetHashOfFile(ctx commands.Context, fpath string) (string, error) {
-	args := []string{"add", "-n", "--cid-version", strconv.Itoa(1), fpath}
-	req, cmd, err := NewRequest(ctx, args)
+	if err := fileAdder.AddFile(file); err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	root, err := fileAdder.Finalize()
 	if err != nil {
 		return "", err
 	}
-	res := commands.NewResponse(req)
-	cmd.PreRun(req)
-	cmd.Run(req, res)
-	var fileHash string
-	for r := range res.Output().(<-chan interface{}) {
-		fileHash = r.(*coreunix.AddedObject).Hash
+	if fileAdder.Pin {
+		if err := fileAdder.PinRoot(); err != nil {
+			return "", err
+		}
 	}
-	cmd.PostRun(req, res)
-	if res.Error() != nil {
-		return "", res.Error()
+	return root.Cid().String(), nil
+}
+
+// Resursively add a directory to IPFS and return the root hash
+func AddDirectory(ctx context.Context, cctx commands.Context, fpath string) (string, error) {
+	return AddDirectoryWithOptions(ctx, cctx, fpath, DefaultAddOptions())
+}
+
+// Resursively add a directory to IPFS with opts and return the root hash
+func AddDirectoryWithOptions(ctx context.Context, cctx commands.Context, fpath string, opts AddOptions) (string, error) {
+	return addPath(ctx, cctx, fpath, true, opts)
+}
+
+func AddFile(ctx context.Context, cctx commands.Context, fpath string) (string, error) {
+	return AddFileWithOptions(ctx, cctx, fpath, DefaultAddOptions())
+}
+
+// Add a file to IPFS with opts and return its hash
+func AddFileWithOptions(ctx context.Context, cctx commands.Context, fpath string, opts AddOptions) (string, error) {
+	return addPath(ctx, cctx, fpath, false, opts)
+}
+
+func GetHashOfFile(ctx context.Context, cctx commands.Context, fpath string) (string, error) {
+	opts := DefaultAddOptions()
+	opts.OnlyHash = true
+	return AddFileWithOptions(ctx, cctx, fpath, opts)
+}
+
+// ctxFile stops reading a file or directory once ctx is done, which
+// aborts the adder
+type ctxFile struct {
+	files.File
+	ctx context.Context
+}
+
+func withContext(ctx context.Context, f files.File) files.File {
+	// The adder recognizes symlinks by their type, they are not read anyway
+	if _, ok := f.(*files.Symlink); ok {
+		return f
 	}
-	if fileHash == "" {
-		return "", addErr
+	return ctxFile{f, ctx}
+}
+
+func (f ctxFile) Read(p []byte) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
 	}
-	return fileHash, nil
+	return f.File.Read(p)
 }
 
-func GetHash(ctx commands.Context, reader io.Reader) (string, error) {
-	tmpPath := path.Join(ctx.ConfigRoot, strconv.Itoa(rand.Int()))
-	f, err := os.Create(tmpPath)
+func (f ctxFile) NextFile() (files.File, error) {
+	if err := f.ctx.Err(); err != nil {
+		return nil, err
+	}
+	next, err := f.File.NextFile()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return withContext(f.ctx, next), nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r        io.Reader
+	n        int64
+	progress func(int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if n > 0 && c.progress != nil {
+		c.progress(c.n)
 	}
-	b, err := ioutil.ReadAll(reader)
+	return n, err
+}
+
+// AddReader adds everything read from r with opts, and returns its hash and
+// size. r is streamed through the importer, nothing is buffered beyond the
+// current chunk.
+func AddReader(ctx context.Context, cctx commands.Context, r io.Reader, opts AddOptions) (_ string, _ int64, err error) {
+	defer wrapErr(&err, "add", opts.Name)
+	cr := &countingReader{r: r, progress: opts.Progress}
+	file := files.NewReaderFile(opts.Name, opts.Name, ioutil.NopCloser(cr), nil)
+	hash, err := addFiles(ctx, cctx, withContext(ctx, file), opts)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
-	f.Write(b)
-	defer f.Close()
-	defer os.Remove(tmpPath)
-	return GetHashOfFile(ctx, tmpPath)
+	return hash, cr.n, nil
+}
+
+// HashReader returns the hash AddReader would add r under with opts, and
+// its size, without storing anything
+func HashReader(ctx context.Context, cctx commands.Context, r io.Reader, opts AddOptions) (string, int64, error) {
+	opts.OnlyHash = true
+	return AddReader(ctx, cctx, r, opts)
+}
+
+// GetHash returns the hash AddFile would return for everything read from
+// reader
+func GetHash(ctx context.Context, cctx commands.Context, reader io.Reader) (string, error) {
+	hash, _, err := HashReader(ctx, cctx, reader, DefaultAddOptions())
+	return hash, err
 }
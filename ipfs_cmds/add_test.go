@@ -1,16 +1,30 @@
 package ipfs_cmds
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
 	"path"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
+
+	cid "gx/ipfs/QmNp85zy9RLrQ5oQD4hPyS39ezrrXpcaa7R4Y9kxdWQLLQ/go-cid"
 )
 
 func TestAddFile(t *testing.T) {
-	ctx, err := MockCmdsCtx()
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
 	if err != nil {
 		t.Error(err)
 	}
-	hash, err := AddFile(ctx, path.Join("./", "root", "test"))
+	hash, err := AddFile(ctx, cctx, path.Join("./", "root", "test"))
 	if err != nil {
 		t.Error(err)
 	}
@@ -20,11 +34,12 @@ func TestAddFile(t *testing.T) {
 }
 
 func TestAddDirectory(t *testing.T) {
-	ctx, err := MockCmdsCtx()
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
 	if err != nil {
 		t.Error(err)
 	}
-	root, err := AddDirectory(ctx, path.Join("./", "root"))
+	root, err := AddDirectory(ctx, cctx, path.Join("./", "root"))
 	if err != nil {
 		t.Error(err)
 	}
@@ -32,3 +47,261 @@ func TestAddDirectory(t *testing.T) {
 		t.Error("Ipfs add directory failed")
 	}
 }
+
+func TestAddConcurrent(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := HashReader(ctx, cctx, bytes.NewReader(catData), DefaultAddOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hash, _, err := AddReader(ctx, cctx, bytes.NewReader(catData), DefaultAddOptions())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if hash != want {
+				t.Errorf("Concurrent add returned %s, want %s", hash, want)
+			}
+			if _, err := Cat(ctx, cctx, hash); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAddReader(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 8<<20 + 17
+	data := func() io.Reader {
+		return io.LimitReader(rand.New(rand.NewSource(2)), size)
+	}
+
+	var progress int64
+	opts := DefaultAddOptions()
+	opts.Progress = func(read int64) { progress = read }
+	hash, n, err := HashReader(ctx, cctx, data(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != size || progress != size {
+		t.Errorf("Hashed %d bytes, progress %d, want %d", n, progress, size)
+	}
+	added, n, err := AddReader(ctx, cctx, data(), DefaultAddOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != hash || n != size {
+		t.Errorf("Added %d bytes as %s, hashed as %s", n, added, hash)
+	}
+
+	// The same as adding a file with the content
+	f, err := ioutil.TempFile("", "ipfs_add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, data()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	fileHash, err := AddFile(ctx, cctx, f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileHash != hash {
+		t.Errorf("AddFile returned %s, AddReader %s", fileHash, hash)
+	}
+
+	r, err := CatStream(ctx, cctx, hash, CatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Size() != size {
+		t.Errorf("Added file has size %d, want %d", r.Size(), size)
+	}
+}
+
+// Content the golden CIDs are computed over, spanning several blocks
+var goldenData = func() []byte {
+	b := make([]byte, 600000)
+	rand.New(rand.NewSource(3)).Read(b)
+	return b
+}()
+
+func TestAddOptionsGolden(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		opts AddOptions
+		data []byte
+		want string
+	}{
+		{"empty v0", AddOptions{}, nil, "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"},
+		{"hello v0", AddOptions{}, []byte("hello world\n"), "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"},
+		{"v0", AddOptions{}, goldenData, "QmdgsWd7xWssKKHFGMH8ETmRxdUc3Hh9QvcaMNFef5CdFV"},
+		{"v0 raw leaves", AddOptions{RawLeaves: true}, goldenData, "QmcVNzp9GeaD4umKQ3SZ98ca8653EEcvECv43cHBuWwN9h"},
+		{"v1", AddOptions{CidVersion: 1}, goldenData, "zdj7WfZ4NAHjtSrkfavZLnPL1opXA3cjUvyYVqPH3S83CwoC4"},
+		{"v1 raw leaves", AddOptions{CidVersion: 1, RawLeaves: true}, goldenData, "zdj7WjadNFD883owd5F8nQB1WT8kJGUwn346XKaiQfrixWGKd"},
+		{"size chunker", AddOptions{Chunker: "size-65536"}, goldenData, "QmV1bj9ewxNGzKYUPoBH7Mymcj4AEJJ1ePm43oxURqx8nf"},
+		{"rabin chunker", AddOptions{Chunker: "rabin-16384-65536-131072"}, goldenData, "QmZsF1rogJyCEoDHfUjNNbpNGybHbdioYqJgXB8J6BnRrc"},
+		{"trickle", AddOptions{Trickle: true, Chunker: "size-4096"}, goldenData, "QmcrK5oixePc7xJt2QaBpVz3GtEP3UCkpkZY95G69dFic3"},
+		{"trickle v1 raw leaves", AddOptions{Trickle: true, Chunker: "size-4096", CidVersion: 1, RawLeaves: true}, goldenData, "zdj7WgDgEh6SchcD2DqweZVUpUnqJigqbP2w3GyqJ1ye17FaL"},
+		{"sha3-256", AddOptions{CidVersion: 1, HashFunc: "sha3-256"}, goldenData, "zdjCkpV767yRxMg5ev9GaQiQdcRVraZ1JBECn8HxjQVLWzCAu"},
+		{"blake2b-256", AddOptions{CidVersion: 1, RawLeaves: true, HashFunc: "blake2b-256"}, goldenData, "zDMZof1kvrKhCZwBFiV1oYRtnLa8dX69Uyu8XM8YFsSTLrRGkP8z"},
+		{"wrap", AddOptions{Wrap: true, Name: "golden"}, goldenData, "QmVNseHuz9Wef1R3LH1vJNv1E8MAJEhejFuJ5XZwBkF3A1"},
+		{"wrap v1", AddOptions{Wrap: true, Name: "golden", CidVersion: 1, RawLeaves: true}, goldenData, "zdj7Wm8szRoScDCtkov6iBp2ZxGCLT4PpRAoK83yEFgJh2eGn"},
+		{"defaults", DefaultAddOptions(), goldenData, "zdj7WjadNFD883owd5F8nQB1WT8kJGUwn346XKaiQfrixWGKd"},
+	} {
+		hash, _, err := HashReader(ctx, cctx, bytes.NewReader(tc.data), tc.opts)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if hash != tc.want {
+			t.Errorf("%s: hashed as %s, want %s", tc.name, hash, tc.want)
+		}
+		added, _, err := AddReader(ctx, cctx, bytes.NewReader(tc.data), tc.opts)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if added != tc.want {
+			t.Errorf("%s: added as %s, want %s", tc.name, added, tc.want)
+		}
+	}
+}
+
+func TestAddDirectoryGolden(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "ipfs_add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "tree")
+	makeTree(t, src)
+
+	for _, tc := range []struct {
+		name string
+		opts AddOptions
+		want string
+	}{
+		{"v0", AddOptions{}, "QmRNp1XYqpgpfszyFMUJt3YCvfGZPdYA99QsK4cAqZZVMu"},
+		{"defaults", DefaultAddOptions(), "zdj7WXcQXrCAn8zzkHvkd8CK49CivxjSH9kK4UaZiNG9z5ueN"},
+		{"wrap", AddOptions{Wrap: true}, "QmX2PsmQXvrKn8NsF5d85oWvYowWQ4JQsFVFBB5ohyHwfz"},
+		{"trickle rabin sha3", AddOptions{Trickle: true, Chunker: "rabin", CidVersion: 1, HashFunc: "sha3-256"}, "zdjCkrSjpw1goWWk22wD1CuZTGxReCRmqVjaFkaNQqbByC4PY"},
+	} {
+		hash, err := AddDirectoryWithOptions(ctx, cctx, src, tc.opts)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if hash != tc.want {
+			t.Errorf("%s: added as %s, want %s", tc.name, hash, tc.want)
+		}
+		tc.opts.OnlyHash = true
+		if hash, err = AddDirectoryWithOptions(ctx, cctx, src, tc.opts); err != nil || hash != tc.want {
+			t.Errorf("%s: hashed as %s (%v), want %s", tc.name, hash, err, tc.want)
+		}
+	}
+}
+
+func TestAddOptionsPin(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, _ := cctx.GetNode()
+	for _, pin := range []bool{false, true} {
+		opts := DefaultAddOptions()
+		opts.Pin = pin
+		data := []byte(fmt.Sprintf("pinned: %v", pin))
+		hash, _, err := AddReader(ctx, cctx, bytes.NewReader(data), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := cid.Decode(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, pinned, err := nd.Pinning.IsPinned(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pinned != pin {
+			t.Errorf("Added with Pin %v, but pinned is %v", pin, pinned)
+		}
+	}
+}
+
+func TestAddOptionsInvalid(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []AddOptions{
+		{CidVersion: 2},
+		{HashFunc: "sha3-256"},
+		{CidVersion: 1, HashFunc: "md5-ish"},
+		{Chunker: "blocks-of-1024"},
+		{Chunker: "size-abc"},
+	} {
+		if _, _, err := HashReader(ctx, cctx, bytes.NewReader(goldenData), opts); err == nil {
+			t.Errorf("Should have refused %+v", opts)
+		}
+	}
+}
+
+func TestAddReaderCancel(t *testing.T) {
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := AddReader(context.Background(), cctx, bytes.NewReader(catData), DefaultAddOptions()); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var read int64
+	opts := DefaultAddOptions()
+	opts.Progress = func(n int64) {
+		read = n
+		if n > 1<<20 {
+			cancel()
+		}
+	}
+	r := io.LimitReader(rand.New(rand.NewSource(4)), 8<<20)
+	if _, _, err := AddReader(ctx, cctx, r, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("AddReader returned %v after cancel", err)
+	}
+	if read >= 8<<20 {
+		t.Errorf("AddReader read everything after cancel")
+	}
+	checkGoroutines(t, before)
+}
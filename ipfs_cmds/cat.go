@@ -1,38 +1,150 @@
 package ipfs_cmds
 
 import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+
 	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core/coreunix"
 	"github.com/ipfs/go-ipfs/path"
-	"io"
-	"time"
+
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 )
 
-// Fetch data from IPFS given the hash
-func Cat(ctx commands.Context, hash string, timeout time.Duration) ([]byte, error) {
-	args := []string{"cat", hash}
-	req, cmd, err := NewRequestWithTimeout(ctx, args, timeout)
+var errNegativeOffset = errors.New("Negative offset or length")
+
+// CatOptions selects the part of a file CatStream reads
+type CatOptions struct {
+	Offset int64 // where to start reading
+	Length int64 // how many bytes to read, 0 reads to the end
+}
+
+// CatReader streams a file out of IPFS
+type CatReader interface {
+	io.ReadSeeker
+	io.Closer
+	Size() uint64 // of the selected part
+}
+
+// catReader limits a DagReader to [start, end), and seeks relative to start
+type catReader struct {
+	path       string
+	r          uio.DagReader
+	ctx        context.Context
+	cancel     context.CancelFunc
+	start, end int64
+	pos        int64
+}
+
+func (c *catReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, newError("cat", c.path, err)
+	}
+	left := c.end - c.start - c.pos
+	if left <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > left {
+		p = p[:left]
+	}
+	n, err := c.r.Read(p)
+	c.pos += int64(n)
+	if err != nil && err != io.EOF {
+		err = newError("cat", c.path, err)
+	}
+	return n, err
+}
+
+func (c *catReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = c.pos + offset
+	case io.SeekEnd:
+		pos = c.end - c.start + offset
+	default:
+		return c.pos, errors.New("Invalid whence")
+	}
+	if pos < 0 {
+		return c.pos, errNegativeOffset
+	}
+	abs := c.start + pos
+	if abs > c.end {
+		abs = c.end
+	}
+	if _, err := c.r.Seek(abs, io.SeekStart); err != nil {
+		return c.pos, err
+	}
+	c.pos = pos
+	return pos, nil
+}
+
+func (c *catReader) Size() uint64 {
+	return uint64(c.end - c.start)
+}
+
+func (c *catReader) Close() error {
+	defer c.cancel()
+	return c.r.Close()
+}
+
+// CatStream opens the file at fpath, an IPFS path or hash, for reading.
+// Only the blocks that are read are fetched, reads fail once ctx is done.
+// The reader must be closed.
+func CatStream(ctx context.Context, cctx commands.Context, fpath string, opts CatOptions) (_ CatReader, err error) {
+	defer wrapErr(&err, "cat", fpath)
+	if opts.Offset < 0 || opts.Length < 0 {
+		return nil, errNegativeOffset
+	}
+	nd, err := cctx.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	p, err := parsePath(fpath)
 	if err != nil {
 		return nil, err
 	}
-	res := commands.NewResponse(req)
-	cmd.Run(req, res)
+	sctx, cancel := context.WithCancel(ctx)
 
-	if res.Error() != nil {
-		return nil, res.Error()
+	r, err := coreunix.Cat(sctx, nd, p.String())
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	size := int64(r.Size())
+	c := &catReader{path: fpath, r: r, ctx: sctx, cancel: cancel, start: opts.Offset, end: size}
+	if c.start > size {
+		c.start = size
+	}
+	if opts.Length > 0 && c.start+opts.Length < size {
+		c.end = c.start + opts.Length
 	}
-	resp := res.Output()
-	reader := resp.(io.Reader)
-	b := make([]byte, res.Length())
-	_, err = reader.Read(b)
+	if c.start > 0 {
+		if _, err := r.Seek(c.start, io.SeekStart); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Fetch data from IPFS given the hash
+func Cat(ctx context.Context, cctx commands.Context, hash string) ([]byte, error) {
+	r, err := CatStream(ctx, cctx, hash, CatOptions{})
 	if err != nil {
 		return nil, err
 	}
-	return b, nil
+	defer r.Close()
+	return ioutil.ReadAll(r)
 }
 
-func ResolveThenCat(ctx commands.Context, ipnsPath path.Path, timeout time.Duration) ([]byte, error) {
+func ResolveThenCat(ctx context.Context, cctx commands.Context, ipnsPath path.Path) ([]byte, error) {
 	var ret []byte
-	hash, err := Resolve(ctx, ipnsPath.Segments()[0], timeout)
+	hash, err := Resolve(ctx, cctx, ipnsPath.Segments()[0])
 	if err != nil {
 		return ret, err
 	}
@@ -41,7 +153,7 @@ func ResolveThenCat(ctx commands.Context, ipnsPath path.Path, timeout time.Durat
 	for i := 0; i < len(ipnsPath.Segments())-1; i++ {
 		p[i+1] = ipnsPath.Segments()[i+1]
 	}
-	b, err := Cat(ctx, path.Join(p), timeout)
+	b, err := Cat(ctx, cctx, path.Join(p))
 	if err != nil {
 		return ret, err
 	}
@@ -0,0 +1,145 @@
+package ipfs_cmds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core/coreunix"
+)
+
+// Larger than a single chunk, so the file spans several blocks
+var catData = func() []byte {
+	b := make([]byte, 1<<20+1234)
+	rand.New(rand.NewSource(1)).Read(b)
+	return b
+}()
+
+func TestCatComplete(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, _ := cctx.GetNode()
+	hash, err := coreunix.Add(nd, bytes.NewReader(catData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Cat(ctx, cctx, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, catData) {
+		t.Errorf("Cat returned %d bytes, want %d", len(b), len(catData))
+	}
+}
+
+func TestCatStreamRange(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, _ := cctx.GetNode()
+	hash, err := coreunix.Add(nd, bytes.NewReader(catData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		offset, length int64
+		want           []byte
+	}{
+		{0, 0, catData},
+		{300000, 0, catData[300000:]},
+		{262140, 10, catData[262140:262150]},
+		{int64(len(catData)) - 5, 100, catData[len(catData)-5:]},
+		{int64(len(catData)) + 5, 0, nil},
+	} {
+		r, err := CatStream(ctx, cctx, hash, CatOptions{Offset: tc.offset, Length: tc.length})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Size() != uint64(len(tc.want)) {
+			t.Errorf("%d+%d: size %d, want %d", tc.offset, tc.length, r.Size(), len(tc.want))
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, tc.want) {
+			t.Errorf("%d+%d: read %d bytes which differ from the file", tc.offset, tc.length, len(b))
+		}
+
+		// Seeking is relative to the offset
+		if len(tc.want) > 2 {
+			if _, err := r.Seek(-2, io.SeekEnd); err != nil {
+				t.Fatal(err)
+			}
+			b, err = ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, tc.want[len(tc.want)-2:]) {
+				t.Errorf("%d+%d: read after seek differs from the file", tc.offset, tc.length)
+			}
+		}
+		r.Close()
+	}
+}
+
+// checkGoroutines fails t if more goroutines than before are still running
+// shortly after an operation was cancelled
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 2)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines running, %d before:\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestCatStreamCancel(t *testing.T) {
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, _ := cctx.GetNode()
+	hash, err := coreunix.Add(nd, bytes.NewReader(catData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Cat(context.Background(), cctx, hash); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := CatStream(ctx, cctx, hash, CatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, context.Canceled) {
+		t.Errorf("Read after cancel returned %v", err)
+	}
+	r.Close()
+
+	if _, err := Cat(ctx, cctx, hash); !errors.Is(err, context.Canceled) {
+		t.Errorf("Cat with a cancelled context returned %v", err)
+	}
+	checkGoroutines(t, before)
+}
@@ -1,35 +1,128 @@
 package ipfs_cmds
 
 import (
+	"context"
+	"errors"
+	"strings"
+
 	"github.com/ipfs/go-ipfs/commands"
-	//ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+	"github.com/ipfs/go-ipfs/core"
+
+	iaddr "github.com/ipfs/go-ipfs/thirdparty/ipfsaddr"
+
+	pstore "gx/ipfs/QmPgDWmTmuzvP7QE5zwo1TmjbJme9pmZHNujB2453jkCTr/go-libp2p-peerstore"
+	swarm "gx/ipfs/QmWpJ4y2vxJ6GZpPfQbpVpQxAYS3UeR6AKNbAHxw7wN3qw/go-libp2p-swarm"
+	ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
 )
 
-type stringList struct {
-	Strings []string
+var errConnNotFound = errors.New("Not connected to this address")
+
+// ConnectResult is the outcome of connecting to or disconnecting from one
+// address
+type ConnectResult struct {
+	Addr   string // as it was given
+	PeerID string // empty if Addr could not be parsed
+	Err    error  // nil on success
 }
 
-func ConnectTo(ctx commands.Context, peerAddr string) ([]string, error) {
-	args := []string{"swarm", "connect"}
-	args = append(args, peerAddr)
-	req, cmd, err := NewRequest(ctx, args)
+// Returns the online node of cctx
+func onlineNode(cctx commands.Context) (*core.IpfsNode, error) {
+	nd, err := cctx.GetNode()
 	if err != nil {
 		return nil, err
 	}
-	res := commands.NewResponse(req)
-	cmd.Run(req, res)
-	if res.Error() != nil {
-		return nil, res.Error()
+	if nd.PeerHost == nil {
+		return nil, ErrOffline
 	}
+	return nd, nil
+}
 
-	//expectedType := reflect.TypeOf(cmd.Type)
-	//var respStrLst expectedType
-	//for _, s := range list.Strings {
-	//	respStrLst = append(respStrLst, s)
-	//}
-	//return respStrLst, nil
-	//return res.Output().(*stringList).Strings, nil
-	var strLst []string
-	strLst = append(strLst, "ajwefkwjelfk")
-	return strLst, nil
+// Connect connects to each of addrs, multiaddrs ending with /ipfs/<peer ID>.
+// It fails only if the node can not connect at all, the outcome for each
+// address is in the result of the same index.
+func Connect(ctx context.Context, cctx commands.Context, addrs []string) (_ []ConnectResult, err error) {
+	defer wrapErr(&err, "connect", strings.Join(addrs, " "))
+	nd, err := onlineNode(cctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]ConnectResult, len(addrs))
+	for i, s := range addrs {
+		res[i].Addr = s
+		addr, err := iaddr.ParseString(s)
+		if err != nil {
+			res[i].Err = newError("connect", s, err)
+			continue
+		}
+		res[i].PeerID = addr.ID().Pretty()
+		pi := pstore.PeerInfo{
+			ID:    addr.ID(),
+			Addrs: []ma.Multiaddr{addr.Transport()},
+		}
+
+		// Try again now, even if recent dials to the peer failed
+		if snet, ok := nd.PeerHost.Network().(*swarm.Network); ok {
+			snet.Swarm().Backoff().Clear(pi.ID)
+		}
+		if err := nd.PeerHost.Connect(ctx, pi); err != nil {
+			res[i].Err = newError("connect", s, err)
+		}
+	}
+	return res, nil
+}
+
+// ConnectTo connects to the peer at peerAddr, a multiaddr ending with
+// /ipfs/<peer ID>
+func ConnectTo(ctx context.Context, cctx commands.Context, peerAddr string) error {
+	res, err := Connect(ctx, cctx, []string{peerAddr})
+	if err != nil {
+		return err
+	}
+	return res[0].Err
+}
+
+// Disconnect closes the connections to each of addrs. A multiaddr ending
+// with /ipfs/<peer ID> closes the connections to the peer at that address,
+// a bare /ipfs/<peer ID> all connections to the peer. The peer may connect
+// again later on.
+func Disconnect(ctx context.Context, cctx commands.Context, addrs []string) (_ []ConnectResult, err error) {
+	defer wrapErr(&err, "disconnect", strings.Join(addrs, " "))
+	nd, err := onlineNode(cctx)
+	if err != nil {
+		return nil, err
+	}
+	network := nd.PeerHost.Network()
+	res := make([]ConnectResult, len(addrs))
+	for i, s := range addrs {
+		res[i].Addr = s
+		if id, err := peer.IDB58Decode(strings.TrimPrefix(s, "/ipfs/")); err == nil {
+			res[i].PeerID = id.Pretty()
+			if len(network.ConnsToPeer(id)) == 0 {
+				res[i].Err = newError("disconnect", s, errConnNotFound)
+			} else if err := network.ClosePeer(id); err != nil {
+				res[i].Err = newError("disconnect", s, err)
+			}
+			continue
+		}
+
+		addr, err := iaddr.ParseString(s)
+		if err != nil {
+			res[i].Err = newError("disconnect", s, err)
+			continue
+		}
+		res[i].PeerID = addr.ID().Pretty()
+		res[i].Err = newError("disconnect", s, errConnNotFound)
+		for _, c := range network.ConnsToPeer(addr.ID()) {
+			if !c.RemoteMultiaddr().Equal(addr.Transport()) {
+				continue
+			}
+			res[i].Err = nil
+			if err := c.Close(); err != nil {
+				res[i].Err = newError("disconnect", s, err)
+			}
+			break
+		}
+	}
+	return res, nil
 }
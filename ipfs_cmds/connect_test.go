@@ -0,0 +1,160 @@
+package ipfs_cmds
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/keystore"
+	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/go-ipfs/repo/config"
+	ds2 "github.com/ipfs/go-ipfs/thirdparty/datastore2"
+	"gx/ipfs/QmVSase1JP7cq9QkPT46oNwdp9pT6kBkG3oqS14y3QcZjG/go-datastore"
+	syncds "gx/ipfs/QmVSase1JP7cq9QkPT46oNwdp9pT6kBkG3oqS14y3QcZjG/go-datastore/sync"
+	testutil "gx/ipfs/QmWRCn8vruNAzHx8i6SAXinuheRitKEGu8c7m26stKvsYx/go-testutil"
+)
+
+// Starts a node listening on a random loopback port, which knows the
+// directions of its connections
+func startNode(t *testing.T) (*core.IpfsNode, commands.Context) {
+	ident, err := testutil.RandIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	skbytes, err := ident.PrivateKey().Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := config.Config{
+		Identity: config.Identity{
+			PeerID:  ident.ID().Pretty(),
+			PrivKey: base64.StdEncoding.EncodeToString(skbytes),
+		},
+		Addresses: config.Addresses{
+			Swarm: []string{"/ip4/127.0.0.1/tcp/0"},
+		},
+	}
+	r := &mockRepo{&repo.Mock{
+		D: ds2.CloserWrap(syncds.MutexWrap(datastore.NewMapDatastore())),
+		C: conf,
+		K: keystore.NewMemKeystore(),
+	}}
+	nd, err := core.NewNode(context.Background(), &core.BuildCfg{
+		Repo:   r,
+		Online: true,
+		Host:   DirectionHostOption,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nd, commands.Context{
+		Online: true,
+		ConstructNode: func() (*core.IpfsNode, error) {
+			return nd, nil
+		},
+	}
+}
+
+// Returns the address other can be dialed at
+func dialAddr(t *testing.T, other *core.IpfsNode) string {
+	addrs := other.PeerHost.Addrs()
+	if len(addrs) == 0 {
+		t.Fatal("Node is not listening")
+	}
+	return addrs[0].String() + "/ipfs/" + other.Identity.Pretty()
+}
+
+func TestConnectAndPeers(t *testing.T) {
+	ctx := context.Background()
+	a, actx := startNode(t)
+	defer a.Close()
+	b, bctx := startNode(t)
+	defer b.Close()
+	c, _ := startNode(t)
+	bAddr := dialAddr(t, b)
+	cAddr := dialAddr(t, c)
+	c.Close()
+
+	res, err := Connect(ctx, actx, []string{bAddr, "/ip4/127.0.0.1/tcp/1", cAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 {
+		t.Fatalf("Got %d results for 3 addresses", len(res))
+	}
+	if res[0].Err != nil || res[0].PeerID != b.Identity.Pretty() {
+		t.Errorf("Connecting to %s: %+v", bAddr, res[0])
+	}
+	if res[1].Err == nil || res[1].PeerID != "" {
+		t.Errorf("Connecting to an address without peer ID: %+v", res[1])
+	}
+	if res[2].Err == nil || res[2].PeerID != c.Identity.Pretty() {
+		t.Errorf("Connecting to a closed node: %+v", res[2])
+	}
+
+	peers, err := ConnectedPeers(ctx, actx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0].ID != b.Identity.Pretty() || peers[0].Direction != DirOutbound {
+		t.Errorf("Peers of the dialing node: %+v", peers)
+	}
+
+	// Identify runs in the background once connected
+	deadline := time.Now().Add(time.Second * 5)
+	for len(peers) == 1 && (peers[0].AgentVersion == "" || len(peers[0].Protocols) == 0) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 50)
+		peers, _ = ConnectedPeers(ctx, actx)
+	}
+	if len(peers) != 1 || peers[0].AgentVersion == "" || len(peers[0].Protocols) == 0 {
+		t.Errorf("Peer was not identified: %+v", peers)
+	}
+
+	peers, err = ConnectedPeers(ctx, bctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0].ID != a.Identity.Pretty() || peers[0].Direction != DirInbound {
+		t.Errorf("Peers of the listening node: %+v", peers)
+	}
+
+	addrs, err := PeerAddrs(ctx, actx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs[b.Identity.Pretty()]) == 0 {
+		t.Errorf("No addresses known for %s: %v", b.Identity.Pretty(), addrs)
+	}
+
+	res, err = Disconnect(ctx, actx, []string{"/ipfs/" + b.Identity.Pretty(), cAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Err != nil {
+		t.Errorf("Disconnecting from %s: %v", b.Identity.Pretty(), res[0].Err)
+	}
+	if !errors.Is(res[1].Err, errConnNotFound) {
+		t.Errorf("Disconnecting from an unconnected address: %v", res[1].Err)
+	}
+	if peers, _ := ConnectedPeers(ctx, actx); len(peers) != 0 {
+		t.Errorf("Still connected to %+v", peers)
+	}
+}
+
+func TestPeersOffline(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConnectedPeers(ctx, cctx); !errors.Is(err, ErrOffline) {
+		t.Errorf("ConnectedPeers on an offline node: %v", err)
+	}
+	if _, err := Connect(ctx, cctx, []string{"/ip4/127.0.0.1/tcp/4001"}); !errors.Is(err, ErrOffline) {
+		t.Errorf("Connect on an offline node: %v", err)
+	}
+}
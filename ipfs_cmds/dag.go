@@ -6,15 +6,13 @@ import (
 	"github.com/ipfs/go-ipfs/merkledag"
 	"gx/ipfs/QmNp85zy9RLrQ5oQD4hPyS39ezrrXpcaa7R4Y9kxdWQLLQ/go-cid"
 	"sync"
-	"time"
 )
 
 // This function takes a Cid directory object and walks it returning each linked cid in the graph
-func FetchGraph(dag merkledag.DAGService, id *cid.Cid) ([]cid.Cid, error) {
+func FetchGraph(ctx context.Context, dag merkledag.DAGService, id *cid.Cid) ([]cid.Cid, error) {
 	var ret []cid.Cid
 	l := new(sync.Mutex)
 	m := make(map[string]bool)
-	ctx := context.Background()
 	m[id.String()] = true
 	for {
 		if len(m) == 0 {
@@ -41,8 +39,8 @@ func FetchGraph(dag merkledag.DAGService, id *cid.Cid) ([]cid.Cid, error) {
 	return ret, nil
 }
 
-func RemoveAll(ctx commands.Context, peerID string) error {
-	hash, err := Resolve(ctx, peerID, time.Minute*5)
+func RemoveAll(ctx context.Context, cctx commands.Context, peerID string) error {
+	hash, err := Resolve(ctx, cctx, peerID)
 	if err != nil {
 		return err
 	}
@@ -50,16 +48,15 @@ func RemoveAll(ctx commands.Context, peerID string) error {
 	if err != nil {
 		return err
 	}
-	nd, err := ctx.GetNode()
+	nd, err := cctx.GetNode()
 	if err != nil {
 		return err
 	}
-	graph, err := FetchGraph(nd.DAG, c)
+	graph, err := FetchGraph(ctx, nd.DAG, c)
 	if err != nil {
 		return err
 	}
 	for _, id := range graph {
-		ctx := context.Background()
 		n, err := nd.DAG.Get(ctx, &id)
 		if err != nil {
 			continue
@@ -0,0 +1,147 @@
+package ipfs_cmds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/blockservice"
+	"github.com/ipfs/go-ipfs/keystore"
+	"github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/namesys"
+	"github.com/ipfs/go-ipfs/path"
+	"github.com/ipfs/go-ipfs/pin"
+
+	routing "gx/ipfs/QmPR2JzfKd9poHx9XBhzoFeBBC31ZM3W5iUPKJZWyaoZZm/go-libp2p-routing"
+	ds "gx/ipfs/QmVSase1JP7cq9QkPT46oNwdp9pT6kBkG3oqS14y3QcZjG/go-datastore"
+	record "gx/ipfs/QmbxkgUceEcuSZ4ZdBA3x74VUDSSYjHYmmeEqkjxbtZ6Jg/go-libp2p-record"
+)
+
+// Kinds of failures, test for them with errors.Is
+var (
+	ErrNotFound         = errors.New("Not found")
+	ErrTimeout          = errors.New("Timed out")
+	ErrInvalidPath      = errors.New("Invalid path")
+	ErrNotPinned        = errors.New("Not pinned")
+	ErrAlreadyPinned    = errors.New("Already pinned")
+	ErrOffline          = errors.New("Node is offline")
+	ErrRecordExpired    = errors.New("IPNS record expired")
+	ErrSignatureInvalid = errors.New("Signature invalid")
+)
+
+// The kinds above, and the errors of go-ipfs and libp2p which are of them
+var errorKinds = []struct {
+	err  error
+	kind error
+}{
+	{ErrNotFound, ErrNotFound},
+	{ErrTimeout, ErrTimeout},
+	{ErrInvalidPath, ErrInvalidPath},
+	{ErrNotPinned, ErrNotPinned},
+	{ErrAlreadyPinned, ErrAlreadyPinned},
+	{ErrOffline, ErrOffline},
+	{ErrRecordExpired, ErrRecordExpired},
+	{ErrSignatureInvalid, ErrSignatureInvalid},
+	{context.DeadlineExceeded, ErrTimeout},
+	{os.ErrNotExist, ErrNotFound},
+	{path.ErrBadPath, ErrInvalidPath},
+	{path.ErrNoComponents, ErrInvalidPath},
+	{merkledag.ErrNotFound, ErrNotFound},
+	{merkledag.ErrLinkNotFound, ErrNotFound},
+	{blockstore.ErrNotFound, ErrNotFound},
+	{blockservice.ErrNotFound, ErrNotFound},
+	{routing.ErrNotFound, ErrNotFound},
+	{ds.ErrNotFound, ErrNotFound},
+	{namesys.ErrResolveFailed, ErrNotFound},
+	{keystore.ErrNoSuchKey, ErrNotFound},
+	{pin.ErrNotPinned, ErrNotPinned},
+	{namesys.ErrExpiredRecord, ErrRecordExpired},
+	{record.ErrBadRecord, ErrSignatureInvalid},
+}
+
+// The namesys resolver reports bad record signatures with this message only
+const badIpnsSignature = "Invalid value. Not signed by"
+
+// Error is returned by the functions of ipfs_cmds. errors.Is reports
+// whether it is of Kind, or wraps Err.
+type Error struct {
+	Op   string // the function which failed, like "cat"
+	Arg  string // the path, hash or name it was called with
+	Kind error  // one of the kinds above, nil if it is none of them
+	Err  error  // the underlying error
+}
+
+func (e *Error) Error() string {
+	msg := e.Op
+	if e.Arg != "" {
+		msg += " " + e.Arg
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// Temporary reports whether trying again later may succeed: the content or
+// name was not found in time, or the node was offline
+func (e *Error) Temporary() bool {
+	switch e.Kind {
+	case ErrNotFound, ErrTimeout, ErrOffline:
+		return true
+	}
+	return false
+}
+
+// IsTemporary reports whether err is worth retrying. Unclassified errors
+// are considered permanent.
+func IsTemporary(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Temporary()
+}
+
+// errorKind returns the kind of err, or nil if it has none
+func errorKind(err error) error {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.kind
+		}
+	}
+	if strings.HasPrefix(err.Error(), badIpnsSignature) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// newError wraps err of op on arg in an *Error, unless it already is one
+func newError(op, arg string, err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Op: op, Arg: arg, Kind: errorKind(err), Err: err}
+}
+
+// wrapErr replaces a non-nil *err with newError(op, arg, *err). Deferred by
+// the exported functions.
+func wrapErr(err *error, op, arg string) {
+	if *err != nil {
+		*err = newError(op, arg, *err)
+	}
+}
+
+// parsePath parses an IPFS or IPNS path, or a bare hash
+func parsePath(s string) (path.Path, error) {
+	p, err := path.ParsePath(s)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidPath, err)
+	}
+	return p, nil
+}
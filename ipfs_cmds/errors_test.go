@@ -0,0 +1,82 @@
+package ipfs_cmds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestErrorKinds(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _, err := AddReader(ctx, cctx, bytes.NewReader([]byte("errors")), DefaultAddOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, _, err := HashReader(ctx, cctx, bytes.NewReader([]byte("never added")), DefaultAddOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	catCtx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	_, catErr := Cat(catCtx, cctx, missing)
+	_, pathErr := Cat(ctx, cctx, "/ipfs/not-a-hash")
+	pinErr := PinAdd(ctx, cctx, hash, true)
+	unpinErr := UnPinDir(ctx, cctx, missing)
+	connectErr := ConnectTo(ctx, cctx, "/ip4/127.0.0.1/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+
+	for _, tc := range []struct {
+		name      string
+		err       error
+		kind      error
+		temporary bool
+	}{
+		{"missing block", catErr, ErrNotFound, true},
+		{"invalid path", pathErr, ErrInvalidPath, false},
+		{"pin twice", pinErr, ErrAlreadyPinned, false},
+		{"unpin unpinned", unpinErr, ErrNotPinned, false},
+		{"connect offline", connectErr, ErrOffline, true},
+	} {
+		if tc.err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+			continue
+		}
+		var e *Error
+		if !errors.As(tc.err, &e) {
+			t.Errorf("%s: %v is not an *Error", tc.name, tc.err)
+			continue
+		}
+		if !errors.Is(tc.err, tc.kind) {
+			t.Errorf("%s: %v is not %v", tc.name, tc.err, tc.kind)
+		}
+		if tc.temporary != IsTemporary(tc.err) {
+			t.Errorf("%s: %v is temporary %v, want %v", tc.name, tc.err, IsTemporary(tc.err), tc.temporary)
+		}
+	}
+}
+
+func TestErrorWrapping(t *testing.T) {
+	err := newError("cat", "QmHash", ErrTimeout)
+	if !errors.Is(err, ErrTimeout) || errors.Is(err, ErrNotFound) {
+		t.Errorf("%v has the wrong kind", err)
+	}
+	if !IsTemporary(err) {
+		t.Errorf("%v should be temporary", err)
+	}
+	if err.Error() != "cat QmHash: Timed out" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+	// Wrapping again keeps the innermost operation
+	if again := newError("get", "", err); again != err {
+		t.Errorf("Rewrapped %v as %v", err, again)
+	}
+	if IsTemporary(errors.New("plain")) {
+		t.Error("Unclassified errors should be permanent")
+	}
+}
@@ -0,0 +1,219 @@
+package ipfs_cmds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+	"github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+	unixfspb "github.com/ipfs/go-ipfs/unixfs/pb"
+	"gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+// Type of forwarding documents
+const ForwardingType = "ipfs_demo/forwarding/v1"
+
+// Forwarding documents are small, larger files are not inspected
+const maxForwardingSize = 16 << 10
+
+// How many forwarding documents ResolveFollow follows before giving up
+const maxForwardingHops = 8
+
+// Forwarding is published under a retired peer ID to point its followers
+// to the new peer ID of the same owner. It is signed by both keys: the old
+// key authorizes the forwarding and the new key consents to it, so no one
+// can forward to a peer ID whose key they do not hold. It does not protect
+// against a compromised old key, whose holder can forward to a key of
+// their own.
+type Forwarding struct {
+	Type         string
+	OldPeerID    string
+	NewPeerID    string
+	Created      time.Time
+	OldPubKey    []byte // libp2p protobuf encoded
+	NewPubKey    []byte
+	OldSignature []byte
+	NewSignature []byte
+}
+
+// The data signed by both keys
+func (f *Forwarding) payload() []byte {
+	return []byte(strings.Join([]string{
+		f.Type,
+		f.OldPeerID,
+		f.NewPeerID,
+		f.Created.UTC().Format(time.RFC3339),
+	}, "\n"))
+}
+
+// NewForwarding returns a forwarding document from the peer ID of oldKey to
+// the one of newKey, signed by both
+func NewForwarding(oldKey, newKey libp2p.PrivKey) (*Forwarding, error) {
+	oldID, err := peer.IDFromPrivateKey(oldKey)
+	if err != nil {
+		return nil, err
+	}
+	newID, err := peer.IDFromPrivateKey(newKey)
+	if err != nil {
+		return nil, err
+	}
+	f := &Forwarding{
+		Type:      ForwardingType,
+		OldPeerID: oldID.Pretty(),
+		NewPeerID: newID.Pretty(),
+		Created:   time.Now().UTC().Truncate(time.Second),
+	}
+	if f.OldPubKey, err = oldKey.GetPublic().Bytes(); err != nil {
+		return nil, err
+	}
+	if f.NewPubKey, err = newKey.GetPublic().Bytes(); err != nil {
+		return nil, err
+	}
+	if f.OldSignature, err = oldKey.Sign(f.payload()); err != nil {
+		return nil, err
+	}
+	if f.NewSignature, err = newKey.Sign(f.payload()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Verify checks f forwards from the peer ID from and is signed by the keys
+// of both peer IDs
+func (f *Forwarding) Verify(from string) error {
+	if f.Type != ForwardingType {
+		return fmt.Errorf("Unknown forwarding document type %q", f.Type)
+	}
+	if f.OldPeerID != from {
+		return fmt.Errorf("Forwarding document of %s found under %s", f.OldPeerID, from)
+	}
+	for _, k := range []struct {
+		id     string
+		pubKey []byte
+		sig    []byte
+	}{
+		{f.OldPeerID, f.OldPubKey, f.OldSignature},
+		{f.NewPeerID, f.NewPubKey, f.NewSignature},
+	} {
+		pk, err := libp2p.UnmarshalPublicKey(k.pubKey)
+		if err != nil {
+			return err
+		}
+		id, err := peer.IDFromPublicKey(pk)
+		if err != nil {
+			return err
+		}
+		if id.Pretty() != k.id {
+			return fmt.Errorf("Forwarding document key does not match peer ID %s", k.id)
+		}
+		if ok, err := pk.Verify(f.payload(), k.sig); err != nil || !ok {
+			return &Error{Op: "verify forwarding", Arg: f.OldPeerID, Kind: ErrSignatureInvalid, Err: ErrSignatureInvalid}
+		}
+	}
+	return nil
+}
+
+// AddForwarding stores f in IPFS, pins it and returns its hash
+func AddForwarding(ctx context.Context, cctx commands.Context, f *Forwarding) (string, error) {
+	nd, err := cctx.ConstructNode()
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+	hash, err := coreunix.AddWithContext(ctx, nd, bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	if err := Pin(ctx, cctx, hash); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// Returns the forwarding document at hash, or nil if it holds something else.
+// Errors fetching the document are returned.
+func readForwarding(ctx context.Context, cctx commands.Context, hash string) (*Forwarding, error) {
+	nd, err := cctx.ConstructNode()
+	if err != nil {
+		return nil, err
+	}
+	p, err := parsePath("/ipfs/" + hash)
+	if err != nil {
+		return nil, err
+	}
+	n, err := core.Resolve(ctx, nd.Namesys, nd.Resolver, p)
+	if err != nil {
+		return nil, err
+	}
+	// Forwarding documents are plain files
+	switch n := n.(type) {
+	case *merkledag.RawNode:
+	case *merkledag.ProtoNode:
+		pb, err := unixfs.FromBytes(n.Data())
+		if err != nil {
+			return nil, nil
+		}
+		if t := pb.GetType(); t != unixfspb.Data_File && t != unixfspb.Data_Raw {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	r, err := uio.NewDagReader(ctx, n, nd.DAG)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if r.Size() > maxForwardingSize {
+		return nil, nil
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f := new(Forwarding)
+	if err := json.Unmarshal(b, f); err != nil || f.Type != ForwardingType {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// ResolveFollow resolves an IPNS name like Resolve, but when the name points
+// to a forwarding document it checks its signatures and resolves the new
+// peer ID instead. It returns the peer ID it ended up at along with the hash.
+func ResolveFollow(ctx context.Context, cctx commands.Context, name string) (peerID string, hash string, err error) {
+	defer wrapErr(&err, "resolve", name)
+	peerID = strings.TrimPrefix(name, "/ipns/")
+	for hops := 0; hops <= maxForwardingHops; hops++ {
+		hash, err = Resolve(ctx, cctx, "/ipns/"+peerID)
+		if err != nil {
+			return "", "", err
+		}
+		f, err := readForwarding(ctx, cctx, hash)
+		if err != nil {
+			return "", "", err
+		}
+		if f == nil {
+			return peerID, hash, nil
+		}
+		if err := f.Verify(peerID); err != nil {
+			return "", "", err
+		}
+		log.Infof("IPNS name %s forwards to %s", peerID, f.NewPeerID)
+		peerID = f.NewPeerID
+	}
+	return "", "", fmt.Errorf("More than %d forwarding documents from %s", maxForwardingHops, name)
+}
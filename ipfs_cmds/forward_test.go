@@ -0,0 +1,108 @@
+package ipfs_cmds
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/unixfs"
+
+	cid "gx/ipfs/QmNp85zy9RLrQ5oQD4hPyS39ezrrXpcaa7R4Y9kxdWQLLQ/go-cid"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+func TestForwardingVerify(t *testing.T) {
+	oldKey, _, err := libp2p.GenerateKeyPair(libp2p.RSA, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, _, err := libp2p.GenerateKeyPair(libp2p.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewForwarding(oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Verify(f.OldPeerID); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Verify(f.NewPeerID); err == nil {
+		t.Error("Should have refused a document found under another name")
+	}
+
+	// Pointing a signed document to another peer ID invalidates it. The
+	// signatures only prove both keys consented to this very forwarding.
+	otherKey, _, err := libp2p.GenerateKeyPair(libp2p.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewForwarding(oldKey, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := *f
+	forged.NewPeerID = other.NewPeerID
+	forged.NewPubKey = other.NewPubKey
+	if err := forged.Verify(f.OldPeerID); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("Expected ErrSignatureInvalid, got %v", err)
+	}
+}
+
+func TestReadForwarding(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey, _, _ := libp2p.GenerateKeyPair(libp2p.Ed25519, 0)
+	newKey, _, _ := libp2p.GenerateKeyPair(libp2p.Ed25519, 0)
+	f, err := NewForwarding(oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := AddForwarding(ctx, cctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := readForwarding(ctx, cctx, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read == nil {
+		t.Fatal("Forwarding document not recognized")
+	}
+	if err := read.Verify(f.OldPeerID); err != nil {
+		t.Error(err)
+	}
+
+	root, err := AddFile(ctx, cctx, "add_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, err := readForwarding(ctx, cctx, root); err != nil || read != nil {
+		t.Errorf("A plain file was taken for a forwarding document: %v", err)
+	}
+
+	nd, err := cctx.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := nd.DAG.Add(unixfs.EmptyDirNode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, err := readForwarding(ctx, cctx, dir.String()); err != nil || read != nil {
+		t.Errorf("A directory was taken for a forwarding document: %v", err)
+	}
+
+	// Not to be mistaken for a name which is not forwarded
+	missing := addUnpinned(t, cctx, []byte("deleted"))
+	c, _ := cid.Decode(missing)
+	if err := nd.Blockstore.DeleteBlock(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readForwarding(ctx, cctx, missing); err == nil {
+		t.Error("Reading a missing document succeeded")
+	}
+}
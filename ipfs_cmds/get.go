@@ -1,31 +1,212 @@
 package ipfs_cmds
 
 import (
-	//"io"
-	"github.com/ipfs/go-ipfs/commands"
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/path"
+
+	uarchive "github.com/ipfs/go-ipfs/unixfs/archive"
 )
 
-func Get(ctx commands.Context, hash string, ofpath string, timeout time.Duration) ([]byte, error) {
-	args := []string{"get", hash, "-o", ofpath}
-	req, cmd, err := NewRequestWithTimeout(ctx, args, timeout)
+// GetOptions controls how Get writes what it fetches
+type GetOptions struct {
+	// Write a tar archive of the file or directory instead of the files
+	Archive bool
+	// gzip compression level of the output, gzip.NoCompression by default.
+	// Without Archive only single files can be compressed.
+	Compression int
+	// Called with the total number of bytes written so far
+	Progress func(written int64)
+}
+
+// GetResult describes what Get wrote
+type GetResult struct {
+	Cid      string        // of the root that was fetched
+	Path     string        // the file or directory written
+	Files    int           // regular files written, 1 for an archive
+	Bytes    int64         // bytes written to all files
+	Duration time.Duration // time taken
+}
+
+// Get writes the file or directory at fpath, an IPFS path or hash, to
+// ofpath. If ofpath is an existing directory, a file or an archive is
+// written into it under its own name, and a directory is merged into it.
+func Get(ctx context.Context, cctx commands.Context, fpath string, ofpath string, opts GetOptions) (_ *GetResult, err error) {
+	defer wrapErr(&err, "get", fpath)
+	start := time.Now()
+	nd, err := cctx.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	p, err := parsePath(fpath)
 	if err != nil {
 		return nil, err
 	}
-	res := commands.NewResponse(req)
-	cmd.PreRun(req)
-	cmd.Run(req, res)
-	if res.Error() != nil {
-		return nil, res.Error()
-	}
-	//resp := res.Output()
-	//reader := resp.(io.Reader)
-	//b := make([]byte, res.Length())
-	//_, err = reader.Read(b)
-	//if err != nil {
-	//	return nil, err
-	//}
-	cmd.PostRun(req, res)
-	b := []byte{'g', 'o', 'l', 'a', 'n', 'g'}
-	return b, nil
+	if opts.Compression < gzip.HuffmanOnly || opts.Compression > gzip.BestCompression {
+		return nil, fmt.Errorf("Compression level %d is out of range", opts.Compression)
+	}
+	dn, err := core.Resolve(ctx, nd.Namesys, nd.Resolver, p)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := uarchive.DagArchive(ctx, dn, p.String(), nd.DAG, opts.Archive, opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	// Stops the archiver if we give up early
+	defer reader.(io.Closer).Close()
+
+	res := &GetResult{Cid: dn.Cid().String(), Path: ofpath}
+	g := &getter{ctx: ctx, progress: opts.Progress, res: res}
+	if opts.Archive || opts.Compression != gzip.NoCompression {
+		if res.Path == "" {
+			res.Path = archiveName(p, opts)
+		} else if stat, err := os.Stat(res.Path); err == nil && stat.IsDir() {
+			res.Path = filepath.Join(res.Path, archiveName(p, opts))
+		}
+		err = g.writeFile(res.Path, reader)
+	} else {
+		if res.Path == "" {
+			res.Path = p.Segments()[len(p.Segments())-1]
+		}
+		err = g.extract(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Duration = time.Since(start)
+	return res, nil
+}
+
+// The default name of an archive of p, like ipfs get uses
+func archiveName(p path.Path, opts GetOptions) string {
+	name := p.Segments()[len(p.Segments())-1]
+	if opts.Archive {
+		name += ".tar"
+	}
+	if opts.Compression != gzip.NoCompression {
+		name += ".gz"
+	}
+	return name
+}
+
+// getter writes the output of a Get and keeps count of it. Writes fail
+// once ctx is done.
+type getter struct {
+	ctx      context.Context
+	progress func(int64)
+	res      *GetResult
+}
+
+func (g *getter) Write(b []byte) (int, error) {
+	if err := g.ctx.Err(); err != nil {
+		return 0, err
+	}
+	g.res.Bytes += int64(len(b))
+	if g.progress != nil {
+		g.progress(g.res.Bytes)
+	}
+	return len(b), nil
+}
+
+// Writes r to the file at fpath, which must not be a directory
+func (g *getter) writeFile(fpath string, r io.Reader) error {
+	if stat, err := os.Stat(fpath); err == nil && stat.IsDir() {
+		return fmt.Errorf("%s is a directory", fpath)
+	}
+	f, err := os.Create(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(io.MultiWriter(f, g), r); err != nil {
+		return err
+	}
+	g.res.Files++
+	return f.Close()
+}
+
+// Extracts the tar stream DagArchive produces to g.res.Path. The root entry
+// becomes the path itself, unless it is a file and the path an existing
+// directory.
+func (g *getter) extract(r io.Reader) error {
+	dest := filepath.Clean(g.res.Path)
+	tr := tar.NewReader(r)
+	for i := 0; ; i++ {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		elems := strings.Split(h.Name, "/")
+		if i == 0 && h.Typeflag == tar.TypeReg {
+			if stat, err := os.Stat(dest); err == nil && stat.IsDir() {
+				dest = filepath.Join(dest, elems[0])
+				g.res.Path = dest
+			}
+		}
+		out := filepath.Join(append([]string{dest}, elems[1:]...)...)
+		if !insideDir(dest, out) {
+			return fmt.Errorf("Refusing to write %s outside of %s", h.Name, dest)
+		}
+		if err := checkNoSymlinks(dest, out); err != nil {
+			return err
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(out, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := g.writeFile(out, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(h.Linkname) || !insideDir(dest, filepath.Join(filepath.Dir(out), h.Linkname)) {
+				return fmt.Errorf("Refusing to link %s to %s outside of %s", h.Name, h.Linkname, dest)
+			}
+			if err := os.Symlink(h.Linkname, out); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Unrecognized tar header type: %d", h.Typeflag)
+		}
+	}
+}
+
+// Returns whether the clean path fpath is dir or inside it
+func insideDir(dir, fpath string) bool {
+	return fpath == dir || strings.HasPrefix(fpath, dir+string(filepath.Separator))
+}
+
+// Fails if out, or one of its parents below dest, is a symlink, which
+// would make writing to out write elsewhere
+func checkNoSymlinks(dest, out string) error {
+	for p := out; p != dest; p = filepath.Dir(p) {
+		stat, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if stat.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("Refusing to write %s through the symlink %s", out, p)
+		}
+	}
+	return nil
 }
@@ -1,22 +1,63 @@
 package ipfs_cmds
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"testing"
-	"time"
 
-	"github.com/mitchellh/go-homedir"
+	"github.com/ipfs/go-ipfs/commands"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+
+	node "gx/ipfs/QmPN7cwmpcc4DWXb4KTB9dNAJgjuPY69h3npsMfhRrQL9c/go-ipld-format"
 )
 
+// Files of the nested directory used by the tests, relative to its root
+var getTree = map[string]string{
+	"a.txt":         "alpha",
+	"sub/b.txt":     "bravo",
+	"sub/deep/c.md": "charlie",
+	"sub/deep/e":    "",
+	"other/d.bin":   string(bytes.Repeat([]byte{7}, 300000)),
+}
+
+func makeTree(t *testing.T, dir string) {
+	for name, data := range getTree {
+		fpath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fpath, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func treeSize() (size int64) {
+	for _, data := range getTree {
+		size += int64(len(data))
+	}
+	return size
+}
+
 func TestGet(t *testing.T) {
-	ctx, err := MockCmdsCtx()
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
 	if err != nil {
 		t.Error(err)
 	}
-	hash, err := AddFile(ctx, path.Join("./", "root", "test"))
+	hash, err := AddFile(ctx, cctx, path.Join("./", "root", "test"))
 	if err != nil {
 		t.Error(err)
 	}
@@ -25,19 +66,312 @@ func TestGet(t *testing.T) {
 	}
 
 	//=========================================== Get ===========================================
-	home, herr := homedir.Dir()
-	if herr != nil {
-		log.Error(herr.Error())
-		os.Exit(1)
-	}
-	var fnamebuf bytes.Buffer
-	fnamebuf.WriteString(hash)
-	ofpath := filepath.Join(home, fnamebuf.String())
-	dataText, err := Get(ctx, hash, ofpath, time.Second*10)
-	if err != nil {
-		log.Info(err.Error())
-		os.Exit(1)
-	} else {
-		log.Infof("Get %s as follow:\n%s", hash, dataText)
+	tmp, err := ioutil.TempDir("", "ipfs_get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	res, err := Get(ctx, cctx, hash, filepath.Join(tmp, hash), GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cid != hash || res.Files != 1 {
+		t.Errorf("Unexpected result %+v", res)
+	}
+}
+
+func TestGetNestedDirectory(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "ipfs_get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "tree")
+	makeTree(t, src)
+	root, err := AddDirectory(ctx, cctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress int64
+	dest := filepath.Join(tmp, "out")
+	res, err := Get(ctx, cctx, root, dest, GetOptions{
+		Progress: func(written int64) { progress = written },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cid != root || res.Path != dest {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if res.Files != len(getTree) || res.Bytes != treeSize() || progress != res.Bytes {
+		t.Errorf("Wrote %d files and %d bytes, progress %d, want %d files and %d bytes",
+			res.Files, res.Bytes, progress, len(getTree), treeSize())
+	}
+	for name, data := range getTree {
+		b, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(b) != data {
+			t.Errorf("%s differs from the added file", name)
+		}
+	}
+}
+
+func TestGetArchive(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "ipfs_get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "tree")
+	makeTree(t, src)
+	root, err := AddDirectory(ctx, cctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, level := range []int{gzip.NoCompression, gzip.BestCompression} {
+		dest := filepath.Join(tmp, "tree.tar")
+		res, err := Get(ctx, cctx, root, dest, GetOptions{Archive: true, Compression: level})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat, _ := f.Stat()
+		if res.Files != 1 || res.Bytes != stat.Size() {
+			t.Errorf("Level %d: result %+v does not match the %d byte archive", level, res, stat.Size())
+		}
+
+		var r io.Reader = f
+		if level != gzip.NoCompression {
+			if r, err = gzip.NewReader(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var files []string
+		tr := tar.NewReader(r)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Typeflag == tar.TypeReg {
+				files = append(files, h.Name)
+			}
+		}
+		f.Close()
+
+		var want []string
+		for name := range getTree {
+			want = append(want, path.Join(root, name))
+		}
+		sort.Strings(files)
+		sort.Strings(want)
+		if len(files) != len(want) {
+			t.Fatalf("Level %d: archive holds %v, want %v", level, files, want)
+		}
+		for i := range want {
+			if files[i] != want[i] {
+				t.Errorf("Level %d: archive holds %s, want %s", level, files[i], want[i])
+			}
+		}
+	}
+}
+
+func TestGetIntoDirectory(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "ipfs_get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "tree")
+	makeTree(t, src)
+	root, err := AddDirectory(ctx, cctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(tmp, "out")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	file := "/ipfs/" + root + "/a.txt"
+	for _, c := range []struct {
+		opts GetOptions
+		name string
+	}{
+		{GetOptions{}, "a.txt"},
+		{GetOptions{Archive: true}, "a.txt.tar"},
+		{GetOptions{Compression: gzip.BestCompression}, "a.txt.gz"},
+		{GetOptions{Archive: true, Compression: gzip.BestCompression}, "a.txt.tar.gz"},
+	} {
+		res, err := Get(ctx, cctx, file, dest, c.opts)
+		if err != nil {
+			t.Errorf("%+v: %s", c.opts, err)
+			continue
+		}
+		want := filepath.Join(dest, c.name)
+		if res.Path != want {
+			t.Errorf("%+v: wrote %s, want %s", c.opts, res.Path, want)
+		}
+		if stat, err := os.Stat(want); err != nil {
+			t.Error(err)
+		} else if stat.Size() != res.Bytes {
+			t.Errorf("%+v: %s holds %d bytes, want %d", c.opts, want, stat.Size(), res.Bytes)
+		}
+	}
+
+	// A directory is merged into the existing one
+	res, err := Get(ctx, cctx, root, dest, GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Path != dest || res.Files != len(getTree) {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dest, "sub", "b.txt")); err != nil || string(b) != "bravo" {
+		t.Errorf("sub/b.txt holds %q, %v", b, err)
+	}
+}
+
+func TestGetCancel(t *testing.T) {
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "ipfs_get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "big")
+	if err := ioutil.WriteFile(src, catData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := AddFile(context.Background(), cctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+
+	// Give up after the first write, the archiver is blocked on the next one
+	for _, archive := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err = Get(ctx, cctx, hash, filepath.Join(tmp, "out"), GetOptions{
+			Archive:  archive,
+			Progress: func(int64) { cancel() },
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Archive %v: Get returned %v after cancel", archive, err)
+		}
+		checkGoroutines(t, before)
+	}
+}
+
+// An entry of a directory built by addDagDir
+type dagEntry struct {
+	name string
+	node node.Node
+}
+
+func symlinkNode(t *testing.T, target string) node.Node {
+	data, err := ft.SymlinkData(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dag.NodeWithData(data)
+}
+
+// Adds a directory with entries, which may repeat names, to the DAG of cctx
+func addDagDir(t *testing.T, cctx commands.Context, entries ...dagEntry) node.Node {
+	nd, err := cctx.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := ft.EmptyDirNode()
+	for _, e := range entries {
+		if _, err := nd.DAG.Add(e.node); err != nil {
+			t.Fatal(err)
+		}
+		if err := dir.AddNodeLink(e.name, e.node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := nd.DAG.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestGetRefusesSymlinkEscapes(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "ipfs_get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	file := dag.NodeWithData(ft.FilePBData([]byte("pwned"), 5))
+	safe := dag.NodeWithData(ft.FilePBData([]byte("safe"), 4))
+	for name, dir := range map[string]node.Node{
+		"absolute": addDagDir(t, cctx, dagEntry{"link", symlinkNode(t, tmp)}),
+		"relative": addDagDir(t, cctx, dagEntry{"link", symlinkNode(t, "../..")}),
+		"nested":   addDagDir(t, cctx, dagEntry{"sub", addDagDir(t, cctx, dagEntry{"link", symlinkNode(t, "../../x")})}),
+		// A file with the name of a symlink is written through it. Links
+		// are sorted by name, so "file" is extracted first.
+		"through": addDagDir(t, cctx,
+			dagEntry{"file", safe},
+			dagEntry{"link", symlinkNode(t, "file")},
+			dagEntry{"link", file},
+		),
+	} {
+		dest := filepath.Join(tmp, name)
+		if _, err := Get(ctx, cctx, dir.Cid().String(), dest, GetOptions{}); err == nil {
+			t.Errorf("%s: extracted an unsafe symlink", name)
+		}
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(tmp, "through", "file")); err != nil || string(b) != "safe" {
+		t.Errorf("Wrote %q through a symlink: %v", b, err)
+	}
+
+	// Links within the output directory are kept
+	dir := addDagDir(t, cctx,
+		dagEntry{"sub", addDagDir(t, cctx, dagEntry{"x", file})},
+		dagEntry{"link", symlinkNode(t, "sub/x")},
+	)
+	dest := filepath.Join(tmp, "inside")
+	if _, err := Get(ctx, cctx, dir.Cid().String(), dest, GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dest, "link")); err != nil || string(b) != "pwned" {
+		t.Errorf("Unexpected link content %q: %v", b, err)
 	}
 }
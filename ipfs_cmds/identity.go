@@ -1,11 +1,18 @@
 package ipfs_cmds
 
 import (
+	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"math/big"
+	"strings"
+
 	"github.com/ipfs/go-ipfs/repo/config"
+	"github.com/tyler-smith/go-bip39"
 	"gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
 	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
 )
@@ -18,23 +25,56 @@ func PeerIdFromPubKey(pk libp2p.PubKey) (string, error) {
 	return id.Pretty(), nil
 }
 
+// Names of the key types accepted by ParseKeyType
+var keyTypeNames = map[int]string{
+	libp2p.RSA:       "rsa",
+	libp2p.Ed25519:   "ed25519",
+	libp2p.Secp256k1: "secp256k1",
+}
+
+// KeyTypeName returns the name of a libp2p key type
+func KeyTypeName(typ int) string {
+	if name, ok := keyTypeNames[typ]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", typ)
+}
+
+// ParseKeyType returns the libp2p key type with the given name
+func ParseKeyType(name string) (int, error) {
+	for typ, n := range keyTypeNames {
+		if strings.EqualFold(n, name) {
+			return typ, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown key type %q", name)
+}
+
 // identityConfig initializes a new identity.
-func IdentityConfig(nbits int) (config.Identity, error) {
+// nbits is only used by RSA keys.
+func IdentityConfig(keyType int, nbits int) (config.Identity, error) {
 	// TODO guard higher up
 	ident := config.Identity{}
-	if nbits < 1024 {
-		return ident, errors.New("Bitsize less than 1024 is considered unsafe.")
+
+	switch keyType {
+	case libp2p.RSA:
+		if nbits < 1024 {
+			return ident, errors.New("Bitsize less than 1024 is considered unsafe.")
+		}
+		fmt.Printf("generating %v-bit RSA keypair...", nbits)
+	case libp2p.Ed25519, libp2p.Secp256k1:
+		fmt.Printf("generating %s keypair...", KeyTypeName(keyType))
+	default:
+		return ident, libp2p.ErrBadKeyType
 	}
 
-	fmt.Printf("generating %v-bit RSA keypair...", nbits)
-	sk, pk, err := libp2p.GenerateKeyPairWithReader(libp2p.RSA, nbits, rand.Reader)
+	sk, pk, err := libp2p.GenerateKeyPairWithReader(keyType, nbits, rand.Reader)
 	if err != nil {
 		return ident, err
 	}
 	fmt.Printf("done\n")
 
-	// currently storing key unencrypted. in the future we need to encrypt it.
-	// TODO(security)
+	// The key is returned unencrypted, see EncryptIdentity
 	skbytes, err := sk.Bytes()
 	if err != nil {
 		return ident, err
@@ -71,12 +111,16 @@ func IdentityFromKey(privkey []byte) (config.Identity, error) {
 	return ident, nil
 }
 
-func IdentityKeyFromSeed(seed []byte, bits int) ([]byte, error) {
-	//tmphmac := hmac.New(sha256.New, []byte("ifps_demo seed"))
-	//tmphmac.Write(seed)
-	//reader := bytes.NewReader(tmphmac.Sum(nil))
-	//sk, _, err := libp2p.GenerateKeyPairWithReader(libp2p.Ed25519, bits, reader)
-	sk, _, err := libp2p.GenerateKeyPairWithReader(libp2p.RSA, bits, rand.Reader)
+// IdentityKeyFromSeed deterministically derives an Ed25519 key from seed
+// and returns it in the libp2p protobuf encoding.
+func IdentityKeyFromSeed(seed []byte) ([]byte, error) {
+	if len(seed) == 0 {
+		return nil, errors.New("Seed must not be empty")
+	}
+	tmphmac := hmac.New(sha256.New, []byte("ifps_demo seed"))
+	tmphmac.Write(seed)
+	reader := bytes.NewReader(tmphmac.Sum(nil))
+	sk, _, err := libp2p.GenerateKeyPairWithReader(libp2p.Ed25519, 0, reader)
 	if err != nil {
 		return nil, err
 	}
@@ -86,3 +130,62 @@ func IdentityKeyFromSeed(seed []byte, bits int) ([]byte, error) {
 	}
 	return encodedKey, nil
 }
+
+// IdentityFromSeed returns the identity derived from seed
+func IdentityFromSeed(seed []byte) (config.Identity, error) {
+	key, err := IdentityKeyFromSeed(seed)
+	if err != nil {
+		return config.Identity{}, err
+	}
+	return IdentityFromKey(key)
+}
+
+// NewMnemonic returns a new random 12 word BIP39 mnemonic
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(128)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// SeedFromMnemonic decodes a BIP39 mnemonic, protected by an optional
+// password, into a seed for IdentityKeyFromSeed
+func SeedFromMnemonic(mnemonic string, password string) ([]byte, error) {
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	if !bip39.IsMnemonicValid(mnemonic) || !mnemonicChecksumValid(strings.Fields(mnemonic)) {
+		return nil, errors.New("Invalid mnemonic")
+	}
+	return bip39.NewSeed(mnemonic, password), nil
+}
+
+// Checks the checksum at the end of the mnemonic words against the entropy
+// they encode. bip39.NewSeedWithErrorChecking can't be used as it drops the
+// leading zero bytes of the entropy and rejects valid mnemonics.
+func mnemonicChecksumValid(words []string) bool {
+	bits := new(big.Int)
+	for _, w := range words {
+		index, ok := bip39.ReverseWordMap[w]
+		if !ok {
+			return false
+		}
+		bits.Lsh(bits, 11)
+		bits.Or(bits, big.NewInt(int64(index)))
+	}
+	checksumBits := uint(len(words) * 11 / 33)
+	checksum := new(big.Int).And(bits, big.NewInt(1<<checksumBits-1))
+	entropy := make([]byte, len(words)*11/33*4)
+	b := bits.Rsh(bits, checksumBits).Bytes()
+	copy(entropy[len(entropy)-len(b):], b)
+	hash := sha256.Sum256(entropy)
+	return checksum.Uint64() == uint64(hash[0]>>(8-checksumBits))
+}
+
+// IdentityFromMnemonic returns the identity derived from a BIP39 mnemonic
+func IdentityFromMnemonic(mnemonic string, password string) (config.Identity, error) {
+	seed, err := SeedFromMnemonic(mnemonic, password)
+	if err != nil {
+		return config.Identity{}, err
+	}
+	return IdentityFromSeed(seed)
+}
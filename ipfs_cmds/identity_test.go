@@ -0,0 +1,104 @@
+package ipfs_cmds
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+func TestIdentityConfigKeyTypes(t *testing.T) {
+	for _, typ := range []int{libp2p.RSA, libp2p.Ed25519, libp2p.Secp256k1} {
+		ident, err := IdentityConfig(typ, 1024)
+		if err != nil {
+			t.Fatalf("%s: %s", KeyTypeName(typ), err)
+		}
+		skbytes, err := base64.StdEncoding.DecodeString(ident.PrivKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sk, err := libp2p.UnmarshalPrivateKey(skbytes)
+		if err != nil {
+			t.Fatalf("%s: %s", KeyTypeName(typ), err)
+		}
+		id, err := PeerIdFromPubKey(sk.GetPublic())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != ident.PeerID {
+			t.Errorf("%s: peer ID mismatch %s != %s", KeyTypeName(typ), id, ident.PeerID)
+		}
+
+		imported, err := IdentityFromKey(skbytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if imported != ident {
+			t.Errorf("%s: IdentityFromKey returned a different identity", KeyTypeName(typ))
+		}
+	}
+}
+
+func TestIdentityConfigRejectsSmallRSA(t *testing.T) {
+	if _, err := IdentityConfig(libp2p.RSA, 512); err == nil {
+		t.Error("Should have refused a 512-bit RSA key")
+	}
+}
+
+func TestIdentityFromMnemonic(t *testing.T) {
+	mnemonic, err := NewMnemonic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident1, err := IdentityFromMnemonic(mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident2, err := IdentityFromMnemonic(mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ident1 != ident2 {
+		t.Error("Same mnemonic derived different identities")
+	}
+	ident3, err := IdentityFromMnemonic(mnemonic, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ident1.PeerID == ident3.PeerID {
+		t.Error("Password did not change the derived identity")
+	}
+	if _, err := IdentityFromMnemonic("not a valid mnemonic", ""); err == nil {
+		t.Error("Should have refused an invalid mnemonic")
+	}
+}
+
+func TestSeedFromMnemonicVectors(t *testing.T) {
+	// BIP39 test vectors with the password TREZOR, the first one has
+	// all-zero entropy
+	for _, v := range []struct{ mnemonic, seed string }{
+		{
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+		},
+		{
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+		},
+	} {
+		seed, err := SeedFromMnemonic(v.mnemonic, "TREZOR")
+		if err != nil {
+			t.Errorf("%q: %s", v.mnemonic, err)
+			continue
+		}
+		if hex.EncodeToString(seed) != v.seed {
+			t.Errorf("%q: unexpected seed %x", v.mnemonic, seed)
+		}
+	}
+
+	// Known words with a wrong checksum
+	if _, err := SeedFromMnemonic("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", ""); err == nil {
+		t.Error("Should have refused a mnemonic with a wrong checksum")
+	}
+}
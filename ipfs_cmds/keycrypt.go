@@ -0,0 +1,163 @@
+package ipfs_cmds
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-ipfs/repo/config"
+	"golang.org/x/crypto/scrypt"
+	"gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+var (
+	ErrWrongPassphrase = errors.New("Wrong passphrase or corrupt encrypted key")
+	ErrKeyLocked       = errors.New("Private key is encrypted, a passphrase is required to unlock it")
+)
+
+// Encrypted keys start with this header. A libp2p protobuf encoded key
+// always starts with 0x08, so the two can not be mistaken for each other.
+var encryptedKeyMagic = []byte("saturn-key-v1\x00")
+
+// scrypt parameters of the key encryption key
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptSalt   = 16
+	scryptKeyLen = 32
+)
+
+// IsEncryptedKey reports whether data was produced by EncryptKey
+func IsEncryptedKey(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedKeyMagic)
+}
+
+func keyCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+	kek, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptKey encrypts a marshalled private key with AES-GCM under a key
+// derived from passphrase with scrypt.
+// The result is the header, the salt, the nonce and the sealed key.
+func EncryptKey(key, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("Passphrase must not be empty")
+	}
+	salt := make([]byte, scryptSalt)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := keyCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, encryptedKeyMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	// The header is authenticated along with the key
+	return aead.Seal(out, nonce, key, encryptedKeyMagic), nil
+}
+
+// DecryptKey reverses EncryptKey. It returns ErrWrongPassphrase when the
+// key can not be authenticated.
+func DecryptKey(data, passphrase []byte) ([]byte, error) {
+	if !IsEncryptedKey(data) {
+		return nil, errors.New("Key is not encrypted")
+	}
+	if len(passphrase) == 0 {
+		return nil, ErrKeyLocked
+	}
+	data = data[len(encryptedKeyMagic):]
+	if len(data) < scryptSalt {
+		return nil, ErrWrongPassphrase
+	}
+	salt, data := data[:scryptSalt], data[scryptSalt:]
+	aead, err := keyCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	key, err := aead.Open(nil, nonce, sealed, encryptedKeyMagic)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+// IdentityEncrypted reports whether the private key of ident is encrypted
+func IdentityEncrypted(ident config.Identity) bool {
+	data, err := base64.StdEncoding.DecodeString(ident.PrivKey)
+	return err == nil && IsEncryptedKey(data)
+}
+
+// EncryptIdentity returns ident with its private key encrypted with
+// passphrase. The identity must not be encrypted already.
+func EncryptIdentity(ident config.Identity, passphrase []byte) (config.Identity, error) {
+	data, err := base64.StdEncoding.DecodeString(ident.PrivKey)
+	if err != nil {
+		return ident, err
+	}
+	if IsEncryptedKey(data) {
+		return ident, errors.New("Identity key is already encrypted")
+	}
+	enc, err := EncryptKey(data, passphrase)
+	if err != nil {
+		return ident, err
+	}
+	ident.PrivKey = base64.StdEncoding.EncodeToString(enc)
+	return ident, nil
+}
+
+// DecryptIdentity returns ident with its private key in the clear.
+// Identities which are not encrypted are returned as is.
+func DecryptIdentity(ident config.Identity, passphrase []byte) (config.Identity, error) {
+	data, err := base64.StdEncoding.DecodeString(ident.PrivKey)
+	if err != nil {
+		return ident, err
+	}
+	if !IsEncryptedKey(data) {
+		return ident, nil
+	}
+	key, err := DecryptKey(data, passphrase)
+	if err != nil {
+		return ident, err
+	}
+
+	// Make sure the key is the one of the peer ID
+	sk, err := libp2p.UnmarshalPrivateKey(key)
+	if err != nil {
+		return ident, err
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return ident, err
+	}
+	if id.Pretty() != ident.PeerID {
+		return ident, fmt.Errorf("Decrypted key does not match peer ID %s", ident.PeerID)
+	}
+	ident.PrivKey = base64.StdEncoding.EncodeToString(key)
+	return ident, nil
+}
@@ -0,0 +1,173 @@
+package ipfs_cmds
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+var testKeyTypes = []int{libp2p.RSA, libp2p.Ed25519, libp2p.Secp256k1}
+
+func TestEncryptIdentityRoundTrip(t *testing.T) {
+	pass := []byte("correct horse battery staple")
+	for _, typ := range testKeyTypes {
+		ident, err := IdentityConfig(typ, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc, err := EncryptIdentity(ident, pass)
+		if err != nil {
+			t.Fatalf("%s: %s", KeyTypeName(typ), err)
+		}
+		if !IdentityEncrypted(enc) || IdentityEncrypted(ident) {
+			t.Fatalf("%s: IdentityEncrypted is wrong", KeyTypeName(typ))
+		}
+		if enc.PeerID != ident.PeerID {
+			t.Errorf("%s: encryption changed the peer ID", KeyTypeName(typ))
+		}
+
+		dec, err := DecryptIdentity(enc, pass)
+		if err != nil {
+			t.Fatalf("%s: %s", KeyTypeName(typ), err)
+		}
+		if dec != ident {
+			t.Errorf("%s: decrypted identity differs from the original", KeyTypeName(typ))
+		}
+
+		if _, err := DecryptIdentity(enc, []byte("wrong")); err != ErrWrongPassphrase {
+			t.Errorf("%s: expected ErrWrongPassphrase, got %v", KeyTypeName(typ), err)
+		}
+		if _, err := DecryptIdentity(enc, nil); err != ErrKeyLocked {
+			t.Errorf("%s: expected ErrKeyLocked, got %v", KeyTypeName(typ), err)
+		}
+	}
+}
+
+func TestDecryptKeyRejectsTampering(t *testing.T) {
+	pass := []byte("secret")
+	enc, err := EncryptKey([]byte("key material"), pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc[len(enc)-1] ^= 1
+	if _, err := DecryptKey(enc, pass); err != ErrWrongPassphrase {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+}
+
+func TestEncryptedKeystoreRekey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ks, err := NewEncryptedKeystore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]libp2p.PrivKey)
+	for _, typ := range testKeyTypes {
+		sk, _, err := libp2p.GenerateKeyPair(typ, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := KeyTypeName(typ)
+		if err := ks.Put(name, sk); err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = sk
+	}
+
+	// Left behind by an interrupted write
+	stray := "." + KeyTypeName(libp2p.RSA) + ".tmp123"
+	if err := ioutil.WriteFile(filepath.Join(dir, stray), []byte("half a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pass := []byte("keystore passphrase")
+	if err := ks.Rekey(pass); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := ks.List(); err != nil || len(names) != len(keys) {
+		t.Errorf("Listed %v (%v), expected %d keys", names, err, len(keys))
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(keys)+1 {
+		t.Errorf("Rekey left %d files, expected the keys and the stray file", len(files))
+	}
+	for name := range keys {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncryptedKey(data) {
+			t.Errorf("%s: key is not encrypted on disk", name)
+		}
+	}
+
+	reopened, err := NewEncryptedKeystore(dir, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, sk := range keys {
+		got, err := reopened.Get(name)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		want, _ := sk.Bytes()
+		have, _ := got.Bytes()
+		if !bytes.Equal(want, have) {
+			t.Errorf("%s: key changed after the round trip", name)
+		}
+	}
+
+	wrong, err := NewEncryptedKeystore(dir, []byte("wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.Get(KeyTypeName(libp2p.Ed25519)); err != ErrWrongPassphrase {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+}
+
+func TestEncryptedKeystoreRejectsInvalidNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ksDir := filepath.Join(dir, "keystore")
+	if err := os.Mkdir(ksDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "outside"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := NewEncryptedKeystore(ksDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "../outside", ".hidden"} {
+		if has, err := ks.Has(name); err == nil {
+			t.Errorf("Has(%q) = %t, expected an invalid name error", name, has)
+		}
+		if _, err := ks.Get(name); err == nil {
+			t.Errorf("Get(%q) accepted an invalid name", name)
+		}
+		if err := ks.Delete(name); err == nil {
+			t.Errorf("Delete(%q) accepted an invalid name", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "outside")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,364 @@
+package ipfs_cmds
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-ipfs/keystore"
+	"github.com/ipfs/go-ipfs/repo/config"
+	btcec "gx/ipfs/QmWq5PJgAQKDWQerAijYUVKW8mN5MDatK5j7VMp8rizKQd/btcec"
+	"gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	pb "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto/pb"
+)
+
+// KeyFormat is an encoding of private and public keys
+type KeyFormat int
+
+const (
+	KeyFormatProtobuf KeyFormat = iota // libp2p protobuf, as in the repo config and keystore
+	KeyFormatDER                       // PKCS#8 for private keys, PKIX for public keys
+	KeyFormatPEM                       // PEM encoded KeyFormatDER
+)
+
+var keyFormatNames = map[KeyFormat]string{
+	KeyFormatProtobuf: "protobuf",
+	KeyFormatDER:      "der",
+	KeyFormatPEM:      "pem",
+}
+
+func (f KeyFormat) String() string {
+	if name, ok := keyFormatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(f))
+}
+
+// ParseKeyFormat returns the key format with the given name
+func ParseKeyFormat(name string) (KeyFormat, error) {
+	for f, n := range keyFormatNames {
+		if strings.EqualFold(n, name) {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown key format %q", name)
+}
+
+// PEM block types
+const (
+	pemPrivateKey    = "PRIVATE KEY"
+	pemPublicKey     = "PUBLIC KEY"
+	pemRsaPrivateKey = "RSA PRIVATE KEY" // PKCS#1, import only
+	pemRsaPublicKey  = "RSA PUBLIC KEY"  // PKIX, written by older versions, import only
+)
+
+// The standard library does not know about secp256k1, its keys are
+// encoded by hand as SEC1 EC keys
+var (
+	oidPublicKeyEC = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSecp256k1   = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+type pkcs8Key struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+type pkixPublicKey struct {
+	Algo      pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+func secp256k1Algo() (pkix.AlgorithmIdentifier, error) {
+	param, err := asn1.Marshal(oidSecp256k1)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{
+		Algorithm:  oidPublicKeyEC,
+		Parameters: asn1.RawValue{FullBytes: param},
+	}, nil
+}
+
+func isSecp256k1Algo(algo pkix.AlgorithmIdentifier) bool {
+	if !algo.Algorithm.Equal(oidPublicKeyEC) {
+		return false
+	}
+	var curve asn1.ObjectIdentifier
+	_, err := asn1.Unmarshal(algo.Parameters.FullBytes, &curve)
+	return err == nil && curve.Equal(oidSecp256k1)
+}
+
+// ExportPrivateKey encodes sk in format
+func ExportPrivateKey(sk libp2p.PrivKey, format KeyFormat) ([]byte, error) {
+	skbytes, err := sk.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if format == KeyFormatProtobuf {
+		return skbytes, nil
+	}
+
+	pbkey := new(pb.PrivateKey)
+	if err := proto.Unmarshal(skbytes, pbkey); err != nil {
+		return nil, err
+	}
+	var der []byte
+	switch pbkey.GetType() {
+	case pb.KeyType_RSA:
+		rsaKey, err := x509.ParsePKCS1PrivateKey(pbkey.GetData())
+		if err != nil {
+			return nil, err
+		}
+		der, err = x509.MarshalPKCS8PrivateKey(rsaKey)
+		if err != nil {
+			return nil, err
+		}
+	case pb.KeyType_Ed25519:
+		// The libp2p data is the 64 byte private key followed by the public key
+		der, err = x509.MarshalPKCS8PrivateKey(ed25519.PrivateKey(pbkey.GetData()[:ed25519.PrivateKeySize]))
+		if err != nil {
+			return nil, err
+		}
+	case pb.KeyType_Secp256k1:
+		priv, pub := btcec.PrivKeyFromBytes(btcec.S256(), pbkey.GetData())
+		point := pub.SerializeUncompressed()
+		ecKey, err := asn1.Marshal(ecPrivateKey{
+			Version:    1,
+			PrivateKey: priv.Serialize(),
+			PublicKey:  asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		algo, err := secp256k1Algo()
+		if err != nil {
+			return nil, err
+		}
+		der, err = asn1.Marshal(pkcs8Key{Algo: algo, PrivateKey: ecKey})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, libp2p.ErrBadKeyType
+	}
+	return encodeDER(der, pemPrivateKey, format)
+}
+
+// ExportPublicKey encodes pk in format
+func ExportPublicKey(pk libp2p.PubKey, format KeyFormat) ([]byte, error) {
+	pkbytes, err := pk.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if format == KeyFormatProtobuf {
+		return pkbytes, nil
+	}
+
+	pbkey := new(pb.PublicKey)
+	if err := proto.Unmarshal(pkbytes, pbkey); err != nil {
+		return nil, err
+	}
+	var der []byte
+	switch pbkey.GetType() {
+	case pb.KeyType_RSA:
+		// Already PKIX
+		der = pbkey.GetData()
+	case pb.KeyType_Ed25519:
+		der, err = x509.MarshalPKIXPublicKey(ed25519.PublicKey(pbkey.GetData()))
+		if err != nil {
+			return nil, err
+		}
+	case pb.KeyType_Secp256k1:
+		pub, err := btcec.ParsePubKey(pbkey.GetData(), btcec.S256())
+		if err != nil {
+			return nil, err
+		}
+		algo, err := secp256k1Algo()
+		if err != nil {
+			return nil, err
+		}
+		point := pub.SerializeUncompressed()
+		der, err = asn1.Marshal(pkixPublicKey{
+			Algo:      algo,
+			PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, libp2p.ErrBadKeyType
+	}
+	return encodeDER(der, pemPublicKey, format)
+}
+
+func encodeDER(der []byte, pemType string, format KeyFormat) ([]byte, error) {
+	switch format {
+	case KeyFormatDER:
+		return der, nil
+	case KeyFormatPEM:
+		return pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der}), nil
+	default:
+		return nil, fmt.Errorf("Unknown key format %s", format)
+	}
+}
+
+// ImportPrivateKey decodes a private key in format.
+// PEM input may also hold a PKCS#1 "RSA PRIVATE KEY" block.
+func ImportPrivateKey(data []byte, format KeyFormat) (libp2p.PrivKey, error) {
+	switch format {
+	case KeyFormatProtobuf:
+		return libp2p.UnmarshalPrivateKey(data)
+	case KeyFormatDER:
+		return parsePKCS8(data)
+	case KeyFormatPEM:
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("Failed to parse PEM block containing the key")
+		}
+		switch block.Type {
+		case pemPrivateKey:
+			return parsePKCS8(block.Bytes)
+		case pemRsaPrivateKey:
+			return libp2p.UnmarshalRsaPrivateKey(block.Bytes)
+		default:
+			return nil, fmt.Errorf("Unexpected PEM block type %q", block.Type)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown key format %s", format)
+	}
+}
+
+func parsePKCS8(der []byte) (libp2p.PrivKey, error) {
+	var p8 pkcs8Key
+	if _, err := asn1.Unmarshal(der, &p8); err == nil && isSecp256k1Algo(p8.Algo) {
+		var ecKey ecPrivateKey
+		if _, err := asn1.Unmarshal(p8.PrivateKey, &ecKey); err != nil {
+			return nil, err
+		}
+		return libp2p.UnmarshalSecp256k1PrivateKey(ecKey.PrivateKey)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return libp2p.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(k))
+	case ed25519.PrivateKey:
+		// libp2p keeps a copy of the public key after the private key
+		data := append(append([]byte{}, k...), k.Public().(ed25519.PublicKey)...)
+		return libp2p.UnmarshalEd25519PrivateKey(data)
+	default:
+		return nil, libp2p.ErrBadKeyType
+	}
+}
+
+// ImportPublicKey decodes a public key in format
+func ImportPublicKey(data []byte, format KeyFormat) (libp2p.PubKey, error) {
+	switch format {
+	case KeyFormatProtobuf:
+		return libp2p.UnmarshalPublicKey(data)
+	case KeyFormatDER:
+		return parsePKIX(data)
+	case KeyFormatPEM:
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("Failed to parse PEM block containing the key")
+		}
+		if block.Type != pemPublicKey && block.Type != pemRsaPublicKey {
+			return nil, fmt.Errorf("Unexpected PEM block type %q", block.Type)
+		}
+		return parsePKIX(block.Bytes)
+	default:
+		return nil, fmt.Errorf("Unknown key format %s", format)
+	}
+}
+
+func parsePKIX(der []byte) (libp2p.PubKey, error) {
+	var spki pkixPublicKey
+	if _, err := asn1.Unmarshal(der, &spki); err == nil && isSecp256k1Algo(spki.Algo) {
+		return libp2p.UnmarshalSecp256k1PublicKey(spki.PublicKey.RightAlign())
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return libp2p.UnmarshalRsaPublicKey(der)
+	case ed25519.PublicKey:
+		return libp2p.UnmarshalEd25519PublicKey(k)
+	default:
+		return nil, libp2p.ErrBadKeyType
+	}
+}
+
+// ExportIdentityKey encodes the private key of ident in format.
+// An encrypted identity must be decrypted with DecryptIdentity first.
+func ExportIdentityKey(ident config.Identity, format KeyFormat) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(ident.PrivKey)
+	if err != nil {
+		return nil, err
+	}
+	if IsEncryptedKey(data) {
+		return nil, ErrKeyLocked
+	}
+	sk, err := libp2p.UnmarshalPrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return ExportPrivateKey(sk, format)
+}
+
+// ImportIdentityKey returns the identity of a private key in format
+func ImportIdentityKey(data []byte, format KeyFormat) (config.Identity, error) {
+	sk, err := ImportPrivateKey(data, format)
+	if err != nil {
+		return config.Identity{}, err
+	}
+	skbytes, err := sk.Bytes()
+	if err != nil {
+		return config.Identity{}, err
+	}
+	return IdentityFromKey(skbytes)
+}
+
+// ExportKeystoreKey encodes the key called name in ks in format
+func ExportKeystoreKey(ks keystore.Keystore, name string, format KeyFormat) ([]byte, error) {
+	sk, err := ks.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return ExportPrivateKey(sk, format)
+}
+
+// ImportKeystoreKey stores a private key in format in ks under name
+func ImportKeystoreKey(ks keystore.Keystore, name string, data []byte, format KeyFormat) (libp2p.PrivKey, error) {
+	sk, err := ImportPrivateKey(data, format)
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.Put(name, sk); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
@@ -0,0 +1,285 @@
+package ipfs_cmds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/keystore"
+	"github.com/ipfs/go-ipfs/namesys"
+	"github.com/ipfs/go-ipfs/routing/offline"
+	"gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+// SelfKey is the name of the node identity key
+const SelfKey = "self"
+
+var errSelfKey = errors.New("The self key can not be changed with the keystore API")
+
+var errSameKeyName = errors.New("The new key name is the same as the old one")
+
+// KeyInfo describes a named key
+type KeyInfo struct {
+	Name string
+	Id   string // peer ID of the key, the IPNS name it publishes to
+	Type int
+}
+
+func keyInfo(name string, sk libp2p.PrivKey) (KeyInfo, error) {
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	info := KeyInfo{Name: name, Id: id.Pretty()}
+	switch sk.(type) {
+	case *libp2p.RsaPrivateKey:
+		info.Type = libp2p.RSA
+	case *libp2p.Ed25519PrivateKey:
+		info.Type = libp2p.Ed25519
+	case *libp2p.Secp256k1PrivateKey:
+		info.Type = libp2p.Secp256k1
+	}
+	return info, nil
+}
+
+func nodeKeystore(ctx context.Context, cctx commands.Context) (*core.IpfsNode, keystore.Keystore, error) {
+	nd, err := cctx.ConstructNode()
+	if err != nil {
+		return nil, nil, err
+	}
+	ks := nd.Repo.Keystore()
+	if ks == nil {
+		return nil, nil, errors.New("The repo has no keystore")
+	}
+	return nd, ks, nil
+}
+
+// OfflinePublisher returns a publisher which only writes the records of sk
+// to the local datastore. The republisher pushes them to the network later on.
+func OfflinePublisher(nd *core.IpfsNode, sk libp2p.PrivKey) namesys.Publisher {
+	return namesys.NewRoutingPublisher(offline.NewOfflineRouter(nd.Repo.Datastore(), sk), nd.Repo.Datastore())
+}
+
+// Publishes an empty directory under sk, like Init does for the self key
+func initializeKeyspace(ctx context.Context, nd *core.IpfsNode, sk libp2p.PrivKey) error {
+	return namesys.InitializeKeyspace(ctx, nd.DAG, OfflinePublisher(nd, sk), nd.Pinning, sk)
+}
+
+// Stores sk under name and initializes its keyspace
+func addKey(ctx context.Context, cctx commands.Context, name string, sk libp2p.PrivKey) (KeyInfo, error) {
+	if name == SelfKey {
+		return KeyInfo{}, errSelfKey
+	}
+	nd, ks, err := nodeKeystore(ctx, cctx)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if err := ks.Put(name, sk); err != nil {
+		return KeyInfo{}, err
+	}
+	if err := initializeKeyspace(ctx, nd, sk); err != nil {
+		ks.Delete(name)
+		return KeyInfo{}, err
+	}
+	return keyInfo(name, sk)
+}
+
+// KeyGen generates a key called name and initializes its IPNS name with
+// an empty directory. bits is only used by RSA keys.
+func KeyGen(ctx context.Context, cctx commands.Context, name string, typ, bits int) (KeyInfo, error) {
+	if typ == libp2p.RSA && bits < 1024 {
+		return KeyInfo{}, errors.New("Bitsize less than 1024 is considered unsafe.")
+	}
+	sk, _, err := libp2p.GenerateKeyPair(typ, bits)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	return addKey(ctx, cctx, name, sk)
+}
+
+// KeyImport stores a private key in format as name and initializes its
+// IPNS name with an empty directory
+func KeyImport(ctx context.Context, cctx commands.Context, name string, data []byte, format KeyFormat) (KeyInfo, error) {
+	sk, err := ImportPrivateKey(data, format)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	return addKey(ctx, cctx, name, sk)
+}
+
+// KeyExport returns the key called name in format. SelfKey is the node
+// identity key.
+func KeyExport(ctx context.Context, cctx commands.Context, name string, format KeyFormat) ([]byte, error) {
+	nd, err := cctx.ConstructNode()
+	if err != nil {
+		return nil, err
+	}
+	sk, err := nd.GetKey(name)
+	if err != nil {
+		return nil, err
+	}
+	return ExportPrivateKey(sk, format)
+}
+
+// KeyList returns the self key followed by the keystore keys sorted by name
+func KeyList(ctx context.Context, cctx commands.Context) ([]KeyInfo, error) {
+	nd, ks, err := nodeKeystore(ctx, cctx)
+	if err != nil {
+		return nil, err
+	}
+	names, err := ks.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var keys []KeyInfo
+	if nd.PrivateKey != nil {
+		self, err := keyInfo(SelfKey, nd.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, self)
+	}
+	for _, name := range names {
+		sk, err := ks.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("Reading key %s: %s", name, err)
+		}
+		info, err := keyInfo(name, sk)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, info)
+	}
+	return keys, nil
+}
+
+// KeyRename renames a keystore key. An existing key called newName is only
+// replaced if force is set. The IPNS name of the key does not change.
+func KeyRename(ctx context.Context, cctx commands.Context, name, newName string, force bool) (KeyInfo, error) {
+	if name == SelfKey || newName == SelfKey {
+		return KeyInfo{}, errSelfKey
+	}
+	if name == newName {
+		return KeyInfo{}, errSameKeyName
+	}
+	_, ks, err := nodeKeystore(ctx, cctx)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	sk, err := renameKey(ks, name, newName, force)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	return keyInfo(newName, sk)
+}
+
+// Moves the key name to newName. The key it replaces is put back if the
+// move fails, so both keys are kept on errors.
+func renameKey(ks keystore.Keystore, name, newName string, force bool) (libp2p.PrivKey, error) {
+	sk, err := ks.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := ks.Has(newName)
+	if err != nil {
+		return nil, err
+	}
+	var replaced libp2p.PrivKey
+	if exists {
+		if !force {
+			return nil, keystore.ErrKeyExists
+		}
+		if replaced, err = ks.Get(newName); err != nil {
+			return nil, err
+		}
+		if err := ks.Delete(newName); err != nil {
+			return nil, err
+		}
+	}
+	if err := ks.Put(newName, sk); err != nil {
+		if replaced != nil {
+			if rerr := ks.Put(newName, replaced); rerr != nil {
+				log.Errorf("Failed to restore key %s: %s", newName, rerr)
+			}
+		}
+		return nil, err
+	}
+	if err := ks.Delete(name); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// KeyRemove deletes a keystore key. Its records are no longer republished.
+func KeyRemove(ctx context.Context, cctx commands.Context, name string) (KeyInfo, error) {
+	if name == SelfKey {
+		return KeyInfo{}, errSelfKey
+	}
+	_, ks, err := nodeKeystore(ctx, cctx)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	sk, err := ks.Get(name)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if err := ks.Delete(name); err != nil {
+		return KeyInfo{}, err
+	}
+	return keyInfo(name, sk)
+}
+
+// lookupKey returns the key called name, or whose peer ID is name
+func lookupKey(nd *core.IpfsNode, name string) (libp2p.PrivKey, error) {
+	sk, err := nd.GetKey(name)
+	if err == nil && sk != nil {
+		return sk, nil
+	}
+	if err != nil && err != keystore.ErrNoSuchKey {
+		return nil, err
+	}
+
+	ks := nd.Repo.Keystore()
+	names, err := ks.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range names {
+		sk, err := ks.Get(n)
+		if err != nil {
+			return nil, err
+		}
+		id, err := peer.IDFromPrivateKey(sk)
+		if err != nil {
+			return nil, err
+		}
+		if id.Pretty() == name {
+			return sk, nil
+		}
+	}
+	return nil, keystore.ErrNoSuchKey
+}
+
+// KeyName returns the IPNS name, ie. the peer ID, published to with the key
+// called name
+func KeyName(ctx context.Context, cctx commands.Context, name string) (string, error) {
+	nd, err := cctx.ConstructNode()
+	if err != nil {
+		return "", err
+	}
+	sk, err := nd.GetKey(name)
+	if err != nil {
+		return "", err
+	}
+	info, err := keyInfo(name, sk)
+	if err != nil {
+		return "", err
+	}
+	return info.Id, nil
+}
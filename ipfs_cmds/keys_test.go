@@ -0,0 +1,168 @@
+package ipfs_cmds
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/keystore"
+	"github.com/ipfs/go-ipfs/namesys"
+	"github.com/ipfs/go-ipfs/thirdparty/ds-help"
+
+	"gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+func TestKeyLifecycle(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := cctx.ConstructNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := KeyGen(ctx, cctx, "store", libp2p.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "store" || info.Type != libp2p.Ed25519 {
+		t.Errorf("Unexpected key info %+v", info)
+	}
+
+	// The keyspace of the key holds a record
+	id, err := peer.IDB58Decode(info.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ipnskey := namesys.IpnsKeysForID(id)
+	if has, err := nd.Repo.Datastore().Has(dshelp.NewKeyFromBinary([]byte(ipnskey))); err != nil || !has {
+		t.Errorf("No IPNS record for the new key: %v", err)
+	}
+
+	if _, err := KeyGen(ctx, cctx, SelfKey, libp2p.Ed25519, 0); err == nil {
+		t.Error("Should have refused to overwrite the self key")
+	}
+	if _, err := KeyGen(ctx, cctx, "store", libp2p.Ed25519, 0); err != keystore.ErrKeyExists {
+		t.Errorf("Expected ErrKeyExists, got %v", err)
+	}
+
+	renamed, err := KeyRename(ctx, cctx, "store", "channel", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Id != info.Id {
+		t.Error("Renaming changed the IPNS name of the key")
+	}
+
+	exported, err := KeyExport(ctx, cctx, "channel", KeyFormatPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := KeyRemove(ctx, cctx, "channel"); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := KeyImport(ctx, cctx, "profile", exported, KeyFormatPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Id != info.Id {
+		t.Error("Import changed the IPNS name of the key")
+	}
+
+	// The self key is listed first
+	keys, err := KeyList(ctx, cctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, k := range keys {
+		names = append(names, k.Name)
+	}
+	if len(names) != 2 || names[0] != SelfKey || names[1] != "profile" {
+		t.Errorf("Unexpected keys %v", names)
+	}
+}
+
+// A keystore whose first Put fails
+type failingPutKeystore struct {
+	keystore.Keystore
+	failed bool
+}
+
+func (ks *failingPutKeystore) Put(name string, sk libp2p.PrivKey) error {
+	if !ks.failed {
+		ks.failed = true
+		return errors.New("disk full")
+	}
+	return ks.Keystore.Put(name, sk)
+}
+
+func TestKeyRename(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := KeyGen(ctx, cctx, "a", libp2p.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := KeyGen(ctx, cctx, "b", libp2p.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Renaming a key to itself keeps it
+	for _, force := range []bool{false, true} {
+		if _, err := KeyRename(ctx, cctx, "a", "a", force); err != errSameKeyName {
+			t.Errorf("force=%t: expected errSameKeyName, got %v", force, err)
+		}
+	}
+	if _, err := KeyRename(ctx, cctx, "a", "b", false); err != keystore.ErrKeyExists {
+		t.Errorf("Expected ErrKeyExists, got %v", err)
+	}
+	renamed, err := KeyRename(ctx, cctx, "a", "b", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name != "b" || renamed.Id != a.Id || renamed.Id == b.Id {
+		t.Errorf("Unexpected key after the forced rename %+v", renamed)
+	}
+	keys, err := KeyList(ctx, cctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[1].Name != "b" {
+		t.Errorf("Unexpected keys %+v", keys)
+	}
+}
+
+func TestRenameKeyRollsBack(t *testing.T) {
+	ks := keystore.NewMemKeystore()
+	keys := make(map[string]libp2p.PrivKey)
+	for _, name := range []string{"a", "b"} {
+		sk, _, err := libp2p.GenerateKeyPair(libp2p.Ed25519, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ks.Put(name, sk); err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = sk
+	}
+
+	// A failed rename keeps both the key and the one it would replace
+	for _, newName := range []string{"b", "c"} {
+		if _, err := renameKey(&failingPutKeystore{Keystore: ks}, "a", newName, true); err == nil {
+			t.Fatalf("Renaming to %s succeeded although Put failed", newName)
+		}
+		for name, want := range keys {
+			if sk, err := ks.Get(name); err != nil || !sk.Equals(want) {
+				t.Errorf("Renaming to %s lost key %s: %v", newName, name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package ipfs_cmds
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-ipfs/keystore"
+	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+// EncryptedKeystore is a keystore.Keystore storing its keys in a directory
+// like keystore.FSKeystore, but encrypted with a passphrase.
+// Keys written by FSKeystore are still read, and encrypted when rewritten.
+type EncryptedKeystore struct {
+	dir        string
+	passphrase []byte
+}
+
+var _ keystore.Keystore = (*EncryptedKeystore)(nil)
+
+// NewEncryptedKeystore opens the keystore in dir, creating dir if needed.
+// Keys are written in the clear if passphrase is empty.
+func NewEncryptedKeystore(dir string, passphrase []byte) (*EncryptedKeystore, error) {
+	if _, err := os.Stat(dir); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err := os.Mkdir(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &EncryptedKeystore{dir: dir, passphrase: passphrase}, nil
+}
+
+func validateKeyName(name string) error {
+	if name == "" {
+		return errors.New("Key names must be at least one character")
+	}
+	if strings.Contains(name, "/") {
+		return errors.New("Key names may not contain slashes")
+	}
+	if strings.HasPrefix(name, ".") {
+		return errors.New("Key names may not begin with a period")
+	}
+	return nil
+}
+
+// Has returns whether or not a key exists in the keystore
+func (ks *EncryptedKeystore) Has(name string) (bool, error) {
+	if err := validateKeyName(name); err != nil {
+		return false, err
+	}
+	_, err := os.Stat(filepath.Join(ks.dir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Put stores a key in the keystore
+func (ks *EncryptedKeystore) Put(name string, k libp2p.PrivKey) error {
+	if err := validateKeyName(name); err != nil {
+		return err
+	}
+	b, err := k.Bytes()
+	if err != nil {
+		return err
+	}
+	if has, err := ks.Has(name); err != nil {
+		return err
+	} else if has {
+		return keystore.ErrKeyExists
+	}
+	return ks.write(name, b)
+}
+
+func (ks *EncryptedKeystore) write(name string, key []byte) error {
+	data := key
+	if len(ks.passphrase) > 0 {
+		var err error
+		if data, err = EncryptKey(key, ks.passphrase); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(filepath.Join(ks.dir, name), data)
+}
+
+// Writes data to a temporary file next to path which is synced and renamed
+// to path, so that path is never left half written. The temporary file
+// starts with a period and is not a valid key name.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0600)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Persist the rename
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func (ks *EncryptedKeystore) read(name string) ([]byte, error) {
+	if err := validateKeyName(name); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(ks.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, keystore.ErrNoSuchKey
+		}
+		return nil, err
+	}
+	if IsEncryptedKey(data) {
+		return DecryptKey(data, ks.passphrase)
+	}
+	return data, nil
+}
+
+// Get retrieves a key from the keystore
+func (ks *EncryptedKeystore) Get(name string) (libp2p.PrivKey, error) {
+	key, err := ks.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return libp2p.UnmarshalPrivateKey(key)
+}
+
+// Delete removes a key from the keystore
+func (ks *EncryptedKeystore) Delete(name string) error {
+	if err := validateKeyName(name); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(ks.dir, name))
+}
+
+// List returns the names of the keys in the keystore
+func (ks *EncryptedKeystore) List() ([]string, error) {
+	dir, err := os.Open(ks.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(0)
+	if err != nil {
+		return nil, err
+	}
+	// Skip temporary files of interrupted writes
+	keys := names[:0]
+	for _, name := range names {
+		if validateKeyName(name) == nil {
+			keys = append(keys, name)
+		}
+	}
+	return keys, nil
+}
+
+// Rekey rewrites all the keys of the keystore encrypted with passphrase,
+// or in the clear if it is empty. Later writes use passphrase too.
+// If a key can not be rewritten the keys rewritten so far are restored.
+func (ks *EncryptedKeystore) Rekey(passphrase []byte) error {
+	names, err := ks.List()
+	if err != nil {
+		return err
+	}
+	keys := make(map[string][]byte, len(names))
+	for _, name := range names {
+		if keys[name], err = ks.read(name); err != nil {
+			return err
+		}
+	}
+	old := ks.passphrase
+	ks.passphrase = passphrase
+	for i, name := range names {
+		if err := ks.write(name, keys[name]); err != nil {
+			ks.passphrase = old
+			for _, name := range names[:i] {
+				ks.write(name, keys[name])
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -1,25 +1,26 @@
 package ipfs_cmds
 
 import (
-	"errors"
+	"context"
+	"fmt"
 
 	"github.com/ipfs/go-ipfs/commands"
-	corecmds "github.com/ipfs/go-ipfs/core/commands"
+	"github.com/op/go-logging"
+
+	ipfslog "gx/ipfs/QmSpJByNKFX1sCsHBEp3R73FL4NF6FnQTEGyNAXHm2GS52/go-log"
 )
 
-func Log(ctx commands.Context, subsys string, level string) (string, error) {
-	args := []string{"log", "level", subsys, level}
-	req, cmd, err := NewRequest(ctx, args)
-	if err != nil {
-		return "", err
-	}
-	res := commands.NewResponse(req)
-	cmd.Run(req, res)
+var log = logging.MustGetLogger("ipfs")
 
-	out, ok := res.Output().(*corecmds.MessageOutput)
-	if !ok {
-		return "", errors.New("Get log message failed.")
+// Log sets the level of an IPFS logging subsystem, "all" for all of them
+func Log(ctx context.Context, cctx commands.Context, subsys string, level string) (string, error) {
+	if subsys == "all" {
+		subsys = "*"
 	}
-
-	return out.Message, nil
+	if err := ipfslog.SetLogLevel(subsys, level); err != nil {
+		return "", err
+	}
+	s := fmt.Sprintf("Changed log level of '%s' to '%s'\n", subsys, level)
+	log.Info(s)
+	return s, nil
 }
@@ -2,8 +2,10 @@ package ipfs_cmds
 
 import (
 	"context"
+	"encoding/base64"
 	"github.com/ipfs/go-ipfs/commands"
 	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/keystore"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/config"
 	ds2 "github.com/ipfs/go-ipfs/thirdparty/datastore2"
@@ -18,6 +20,8 @@ import (
 	smux "gx/ipfs/QmY9JXR3FupnYAYJWK9aMr9bCpqWKcToQ1tz8DVGTrHpHw/go-stream-muxer"
 	host "gx/ipfs/QmaSxYRuMq4pkpBBG2CYaRrPx2z7NmMVEs34b9g61biQA6/go-libp2p-host"
 	"net"
+	"sync"
+	"time"
 )
 
 // NewMockNode constructs an IpfsNode for use in tests.
@@ -37,33 +41,72 @@ func MockHostOption(mn mocknet.Mocknet) core.HostOption {
 	}
 }
 
+// mockRepo is a repo.Mock which returns its keystore
+type mockRepo struct {
+	*repo.Mock
+}
+
+func (m *mockRepo) Keystore() keystore.Keystore { return m.K }
+
 func MockCmdsCtx() (commands.Context, error) {
+	return mockCmdsCtx(nil)
+}
+
+// MockNetCmdsCtx is MockCmdsCtx with an online node on mn, which has no
+// routing. Link the peers of mn to connect them.
+func MockNetCmdsCtx(mn mocknet.Mocknet) (commands.Context, error) {
+	return mockCmdsCtx(mn)
+}
+
+// Builds the node of a mock context, online on mn unless it is nil
+func mockCmdsCtx(mn mocknet.Mocknet) (commands.Context, error) {
 	// Generate Identity
 	ident, err := testutil.RandIdentity()
 	if err != nil {
 		return commands.Context{}, err
 	}
 	p := ident.ID()
+	skbytes, err := ident.PrivateKey().Bytes()
+	if err != nil {
+		return commands.Context{}, err
+	}
 
 	conf := config.Config{
 		Identity: config.Identity{
-			PeerID: p.String(),
+			PeerID:  p.Pretty(),
+			PrivKey: base64.StdEncoding.EncodeToString(skbytes),
 		},
 	}
 
-	r := &repo.Mock{
+	if mn != nil {
+		// Listening on mn only records the address
+		conf.Addresses.Swarm = []string{"/ip4/127.0.0.1/tcp/4001"}
+	}
+
+	r := &mockRepo{&repo.Mock{
 		D: ds2.CloserWrap(syncds.MutexWrap(datastore.NewMapDatastore())),
 		C: conf,
-	}
+		K: keystore.NewMemKeystore(),
+	}}
 
-	node, err := core.NewNode(context.Background(), &core.BuildCfg{
+	cfg := &core.BuildCfg{
 		Repo: r,
-	})
+	}
+	if mn != nil {
+		cfg.Online = true
+		cfg.Host = MockHostOption(mn)
+		cfg.Routing = core.NilRouterOption
+	}
+	node, err := core.NewNode(context.Background(), cfg)
 	if err != nil {
 		return commands.Context{}, err
 	}
-
-	node.Identity = p
+	// Set up before the node is shared, for the name system
+	if mn == nil {
+		if err := node.SetupOfflineRouting(); err != nil {
+			return commands.Context{}, err
+		}
+	}
 
 	return commands.Context{
 		Online:     true,
@@ -76,3 +119,27 @@ func MockCmdsCtx() (commands.Context, error) {
 		},
 	}, nil
 }
+
+// MockClock is a Clock for use in tests, which only moves when told to
+type MockClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// NewMockClock returns a MockClock set to now
+func NewMockClock(now time.Time) *MockClock {
+	return &MockClock{now: now}
+}
+
+func (c *MockClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Add moves the clock forward by d
+func (c *MockClock) Add(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
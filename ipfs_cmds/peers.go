@@ -1,17 +1,146 @@
 package ipfs_cmds
 
-import "github.com/ipfs/go-ipfs/commands"
+import (
+	"context"
+	"net"
+	"sort"
+	"time"
 
-func ConnectedPeers(ctx commands.Context) ([]string, error) {
-	args := []string{"swarm", "peers"}
-	req, cmd, err := NewRequest(ctx, args)
+	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core"
+
+	inet "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	pstore "gx/ipfs/QmPgDWmTmuzvP7QE5zwo1TmjbJme9pmZHNujB2453jkCTr/go-libp2p-peerstore"
+	metrics "gx/ipfs/QmQbh3Rb7KM37As3vkHYnEFnzkVXNCP8EYGtHz6g2fXk14/go-libp2p-metrics"
+	ipnet "gx/ipfs/QmQq9YzmdFdWNTDdArueGyD7L5yyiRQigrRHJnTGkxcEjT/go-libp2p-interface-pnet"
+	swarm "gx/ipfs/QmWpJ4y2vxJ6GZpPfQbpVpQxAYS3UeR6AKNbAHxw7wN3qw/go-libp2p-swarm"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	smux "gx/ipfs/QmY9JXR3FupnYAYJWK9aMr9bCpqWKcToQ1tz8DVGTrHpHw/go-stream-muxer"
+	host "gx/ipfs/QmaSxYRuMq4pkpBBG2CYaRrPx2z7NmMVEs34b9g61biQA6/go-libp2p-host"
+)
+
+// Direction of a connection, seen from this node
+type Direction string
+
+const (
+	DirUnknown  Direction = "unknown"  // not built with DirectionHostOption
+	DirInbound  Direction = "inbound"  // accepted by a listener
+	DirOutbound Direction = "outbound" // dialed by this node
+)
+
+// PeerInfo describes a connection to a peer
+type PeerInfo struct {
+	ID           string
+	Addr         string // remote address of the connection
+	Direction    Direction
+	Latency      time.Duration // 0 if not measured yet
+	Streams      int           // open on the connection
+	Protocols    []string      // supported by the peer
+	AgentVersion string        // empty until the peer is identified
+}
+
+// DirectionHostOption builds the host like core.DefaultHostOption, with a
+// stream muxer which records whether connections were accepted or dialed.
+// ConnectedPeers reports DirUnknown for nodes built without it.
+func DirectionHostOption(ctx context.Context, id peer.ID, ps pstore.Peerstore, bwr metrics.Reporter, fs []*net.IPNet, tpt smux.Transport, protec ipnet.Protector, opts *core.ConstructPeerHostOpts) (host.Host, error) {
+	return core.DefaultHostOption(ctx, id, ps, bwr, fs, dirTransport{tpt}, protec, opts)
+}
+
+// dirTransport wraps the muxed connections of a transport in dirConns
+type dirTransport struct {
+	smux.Transport
+}
+
+func (t dirTransport) NewConn(c net.Conn, isServer bool) (smux.Conn, error) {
+	conn, err := t.Transport.NewConn(c, isServer)
+	if err != nil {
+		return nil, err
+	}
+	return dirConn{conn, isServer}, nil
+}
+
+// dirConn is a muxed connection which knows whether it was accepted
+type dirConn struct {
+	smux.Conn
+	inbound bool
+}
+
+// Returns the direction of c
+func direction(c inet.Conn) Direction {
+	sc, ok := c.(*swarm.Conn)
+	if !ok {
+		return DirUnknown
+	}
+	dc, ok := sc.StreamConn().Conn().(dirConn)
+	if !ok {
+		return DirUnknown
+	}
+	if dc.inbound {
+		return DirInbound
+	}
+	return DirOutbound
+}
+
+// ConnectedPeers describes each open connection, sorted by peer ID. The
+// protocols and agent version are those the identify service learned.
+func ConnectedPeers(ctx context.Context, cctx commands.Context) (_ []PeerInfo, err error) {
+	defer wrapErr(&err, "peers", "")
+	nd, err := onlineNode(cctx)
+	if err != nil {
+		return nil, err
+	}
+	peers := nd.PeerHost.Peerstore()
+
+	var out []PeerInfo
+	for _, c := range nd.PeerHost.Network().Conns() {
+		p := c.RemotePeer()
+		info := PeerInfo{
+			ID:        p.Pretty(),
+			Addr:      c.RemoteMultiaddr().String(),
+			Direction: direction(c),
+			Latency:   peers.LatencyEWMA(p),
+		}
+		if streams, err := c.GetStreams(); err == nil {
+			info.Streams = len(streams)
+		}
+		if protos, err := peers.GetProtocols(p); err == nil {
+			sort.Strings(protos)
+			info.Protocols = protos
+		}
+		if av, err := peers.Get(p, "AgentVersion"); err == nil {
+			info.AgentVersion, _ = av.(string)
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ID != out[j].ID {
+			return out[i].ID < out[j].ID
+		}
+		return out[i].Addr < out[j].Addr
+	})
+	return out, nil
+}
+
+// PeerAddrs returns the sorted addresses known for each peer in the
+// peerstore, connected or not
+func PeerAddrs(ctx context.Context, cctx commands.Context) (_ map[string][]string, err error) {
+	defer wrapErr(&err, "addrs", "")
+	nd, err := onlineNode(cctx)
 	if err != nil {
 		return nil, err
 	}
-	res := commands.NewResponse(req)
-	cmd.Run(req, res)
-	if res.Error() != nil {
-		return nil, res.Error()
+	peers := nd.PeerHost.Peerstore()
+	addrs := make(map[string][]string)
+	for _, p := range peers.Peers() {
+		var list []string
+		for _, a := range peers.Addrs(p) {
+			list = append(list, a.String())
+		}
+		if len(list) == 0 {
+			continue
+		}
+		sort.Strings(list)
+		addrs[p.Pretty()] = list
 	}
-	return *res.Output().(*[]string), nil
+	return addrs, nil
 }
@@ -1,72 +1,275 @@
 package ipfs_cmds
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/corerepo"
+	"github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/pin"
 
-	corecmds "github.com/ipfs/go-ipfs/core/commands"
+	"gx/ipfs/QmNp85zy9RLrQ5oQD4hPyS39ezrrXpcaa7R4Y9kxdWQLLQ/go-cid"
+)
+
+// PinType is how a block is pinned
+type PinType string
 
-	"gx/ipfs/QmVmDhyTTUcQXFD1rRQ64fGLMSAoaQvNH3hwuaCFAPq2hy/errors"
+const (
+	PinDirect    PinType = "direct"    // the block alone
+	PinRecursive PinType = "recursive" // the block and everything it links to
+	PinIndirect  PinType = "indirect"  // linked to by a recursive pin
+	PinAll       PinType = "all"       // any of them, for PinLs
 )
 
-/* pin a Object given its hash. */
-func Pin(ctx commands.Context, rootHash string) error {
-	args := []string{"pin", "add", "/ipfs/" + rootHash}
-	req, cmd, err := NewRequest(ctx, args)
+// PinInfo is a pinned block
+type PinInfo struct {
+	Cid  string
+	Type PinType
+}
+
+// PinStatus is the outcome of verifying a recursive pin
+type PinStatus struct {
+	Cid     string
+	Ok      bool     // all its blocks are stored locally
+	Missing []string // blocks which could not be read locally
+}
+
+/* pin a Object given its hash. Pinning it again is not an error. */
+func Pin(ctx context.Context, cctx commands.Context, rootHash string) error {
+	return pinOnce(ctx, cctx, rootHash)
+}
+
+// PinAdd pins the object at rootHash, an IPFS path or hash, along with
+// everything it links to if recursive is set. Missing blocks are fetched.
+// It returns ErrAlreadyPinned if the object is pinned that way already.
+func PinAdd(ctx context.Context, cctx commands.Context, rootHash string, recursive bool) (err error) {
+	defer wrapErr(&err, "pin", rootHash)
+	nd, err := cctx.GetNode()
 	if err != nil {
 		return err
 	}
-	res := commands.NewResponse(req)
-	cmd.Run(req, res)
-	if res.Error() != nil {
-		return res.Error()
+	p, err := parsePath(rootHash)
+	if err != nil {
+		return err
+	}
+	// Sub-paths and IPNS names pin the object they resolve to
+	c, err := core.ResolveToCid(ctx, nd.Namesys, nd.Resolver, p)
+	if err != nil {
+		return err
+	}
+	defer nd.Blockstore.PinLock().Unlock()
+	modes := []pin.PinMode{pin.Recursive}
+	if !recursive {
+		modes = append(modes, pin.Direct)
+	}
+	for _, mode := range modes {
+		if _, pinned, err := nd.Pinning.IsPinnedWithType(c, mode); err != nil {
+			return err
+		} else if pinned {
+			return ErrAlreadyPinned
+		}
+	}
+	_, err = corerepo.Pin(nd, ctx, []string{c.String()}, recursive)
+	return err
+}
+
+/* Recursively pin a directory given its hash. Pinning it again is not an error. */
+func PinDir(ctx context.Context, cctx commands.Context, rootHash string) error {
+	return pinOnce(ctx, cctx, rootHash)
+}
+
+// Pins rootHash recursively unless it is already
+func pinOnce(ctx context.Context, cctx commands.Context, rootHash string) error {
+	if err := PinAdd(ctx, cctx, rootHash, true); err != nil && !errors.Is(err, ErrAlreadyPinned) {
+		return err
 	}
 	return nil
 }
 
-/* Recursively pin a directory given its hash. */
-func PinDir(ctx commands.Context, rootHash string) error {
-	return PinDir(ctx, rootHash)
+// PinRm removes the recursive or direct pin of the object at rootHash.
+// Its blocks may be garbage collected unless pinned otherwise.
+// Recursive pins held by labelled pin owners are not removed, see
+// PinLabelled.
+func PinRm(ctx context.Context, cctx commands.Context, rootHash string, recursive bool) (err error) {
+	defer wrapErr(&err, "unpin", rootHash)
+	nd, err := cctx.GetNode()
+	if err != nil {
+		return err
+	}
+	p, err := parsePath(rootHash)
+	if err != nil {
+		return err
+	}
+	c, err := core.ResolveToCid(ctx, nd.Namesys, nd.Resolver, p)
+	if err != nil {
+		return err
+	}
+	lock := labelledPinLock(nd)
+	lock.Lock()
+	defer lock.Unlock()
+	if owned, err := takeOverPin(nd, c.String(), recursive); err != nil || owned {
+		return err
+	}
+	return pinRm(ctx, nd, c.String(), recursive)
+}
+
+// Removes the pin of the object at fpath without looking at labelled pins
+func pinRm(ctx context.Context, nd *core.IpfsNode, fpath string, recursive bool) error {
+	defer nd.Blockstore.PinLock().Unlock()
+	_, err := corerepo.Unpin(nd, ctx, []string{fpath}, recursive)
+	return err
 }
 
 /* Recursively un-pin a directory given its hash.
    This will allow it to be garbage collected. */
-func UnPinDir(ctx commands.Context, rootHash string) error {
-	args := []string{"pin", "rm", "/ipfs/" + rootHash}
-	req, cmd, err := NewRequest(ctx, args)
+func UnPinDir(ctx context.Context, cctx commands.Context, rootHash string) error {
+	return PinRm(ctx, cctx, rootHash, true)
+}
+
+// PinLs lists the pins of type typ sorted by CID, all of them if typ is
+// PinAll or empty. Listing indirect pins walks every recursive pin.
+func PinLs(ctx context.Context, cctx commands.Context, typ PinType) (_ []PinInfo, err error) {
+	defer wrapErr(&err, "pin ls", string(typ))
+	switch typ {
+	case "":
+		typ = PinAll
+	case PinAll, PinDirect, PinRecursive, PinIndirect:
+	default:
+		return nil, fmt.Errorf("Invalid pin type %q", typ)
+	}
+	nd, err := cctx.GetNode()
+	if err != nil {
+		return nil, err
+	}
+
+	var pins []PinInfo
+	if typ == PinAll || typ == PinDirect {
+		for _, c := range nd.Pinning.DirectKeys() {
+			pins = append(pins, PinInfo{c.String(), PinDirect})
+		}
+	}
+	if typ == PinAll || typ == PinRecursive {
+		for _, c := range nd.Pinning.RecursiveKeys() {
+			pins = append(pins, PinInfo{c.String(), PinRecursive})
+		}
+	}
+	if typ == PinAll || typ == PinIndirect {
+		indirect, err := indirectPins(ctx, nd)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range indirect {
+			pins = append(pins, PinInfo{c.String(), PinIndirect})
+		}
+	}
+	sort.Slice(pins, func(i, j int) bool {
+		return pins[i].Cid < pins[j].Cid
+	})
+	return pins, nil
+}
+
+// Returns the blocks linked to by recursive pins which are not pinned
+// otherwise
+func indirectPins(ctx context.Context, nd *core.IpfsNode) ([]*cid.Cid, error) {
+	set := cid.NewSet()
+	for _, k := range nd.Pinning.RecursiveKeys() {
+		if err := merkledag.EnumerateChildren(ctx, nd.DAG.GetLinks, k, set.Visit); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range nd.Pinning.RecursiveKeys() {
+		set.Remove(c)
+	}
+	for _, c := range nd.Pinning.DirectKeys() {
+		set.Remove(c)
+	}
+	return set.Keys(), nil
+}
+
+// PinUpdate moves the recursive pin of from to to, which are IPFS paths or
+// hashes. Only the blocks of to which are not shared with from are fetched.
+// The pin of from is kept unless unpin is set.
+func PinUpdate(ctx context.Context, cctx commands.Context, from, to string, unpin bool) (err error) {
+	defer wrapErr(&err, "pin update", from)
+	nd, err := cctx.GetNode()
 	if err != nil {
 		return err
 	}
-	res := commands.NewResponse(req)
-	cmd.Run(req, res)
-	if res.Error() != nil {
-		return res.Error()
+	var cids [2]*cid.Cid
+	for i, s := range []string{from, to} {
+		p, err := parsePath(s)
+		if err != nil {
+			return err
+		}
+		if cids[i], err = core.ResolveToCid(ctx, nd.Namesys, nd.Resolver, p); err != nil {
+			return err
+		}
 	}
-	return nil
+
+	defer nd.Blockstore.PinLock().Unlock()
+	if _, pinned, err := nd.Pinning.IsPinnedWithType(cids[0], pin.Recursive); err != nil {
+		return err
+	} else if !pinned {
+		return ErrNotPinned
+	}
+	if err := nd.Pinning.Update(ctx, cids[0], cids[1], unpin); err != nil {
+		return err
+	}
+	return nd.Pinning.Flush()
 }
 
-func PinLs(ctx commands.Context) ([]string, error) {
-	args := []string{"pin", "ls"}
-	req, cmd, err := NewRequest(ctx, args)
+// PinVerify checks the blocks of every recursive pin are stored locally,
+// nothing is fetched. The statuses are in the order of the pins.
+func PinVerify(ctx context.Context, cctx commands.Context) (_ []PinStatus, err error) {
+	defer wrapErr(&err, "pin verify", "")
+	nd, err := cctx.GetNode()
 	if err != nil {
 		return nil, err
 	}
-	res := commands.NewResponse(req)
-	cmd.Run(req, res)
-	if res.Error() != nil {
-		return nil, res.Error()
-	}
-	keys, ok := res.Output().(*corecmds.RefKeyList)
-	if !ok {
-		return nil, errors.Errorf("expected type %T, got an invalid Type", keys)
+	getLinks := nd.DAG.GetOfflineLinkService().GetLinks
+
+	// Missing blocks below each visited block, pins often share blocks
+	visited := make(map[string][]string)
+	var check func(c *cid.Cid) ([]string, error)
+	check = func(c *cid.Cid) ([]string, error) {
+		if missing, ok := visited[c.KeyString()]; ok {
+			return missing, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Raw blocks have no links, which are known without reading them
+		has, err := nd.Blockstore.Has(c)
+		if err != nil {
+			return nil, err
+		}
+		var missing []string
+		links, err := getLinks(ctx, c)
+		if !has || err != nil {
+			missing = []string{c.String()}
+		}
+		for _, l := range links {
+			m, err := check(l.Cid)
+			if err != nil {
+				return nil, err
+			}
+			missing = append(missing, m...)
+		}
+		visited[c.KeyString()] = missing
+		return missing, nil
 	}
-	var objs []string
-	out := new(bytes.Buffer)
-	for k, v := range keys.Keys {
-		fmt.Fprintf(out, "%s %s\n", k, v.Type)
-		objs = append(objs, out.String())
+
+	var out []PinStatus
+	for _, c := range nd.Pinning.RecursiveKeys() {
+		missing, err := check(c)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, PinStatus{Cid: c.String(), Ok: len(missing) == 0, Missing: missing})
 	}
-	return objs, nil
+	return out, nil
 }
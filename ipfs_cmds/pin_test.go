@@ -1,25 +1,241 @@
 package ipfs_cmds
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
 	"path"
+	"path/filepath"
 	"testing"
+
+	"github.com/ipfs/go-ipfs/commands"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+
+	cid "gx/ipfs/QmNp85zy9RLrQ5oQD4hPyS39ezrrXpcaa7R4Y9kxdWQLLQ/go-cid"
 )
 
 func TestUnPinDir(t *testing.T) {
-	ctx, err := MockCmdsCtx()
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
 	if err != nil {
 		t.Error(err)
 	}
-	root, err := AddDirectory(ctx, path.Join("./", "root"))
+	root, err := AddDirectory(ctx, cctx, path.Join("./", "root"))
 	if err != nil {
 		t.Error(err)
 	}
-	err = UnPinDir(ctx, root)
+	err = UnPinDir(ctx, cctx, root)
 	if err != nil {
 		t.Error(err)
 	}
-	err = UnPinDir(ctx, "fasdfasdf")
+	err = UnPinDir(ctx, cctx, "fasdfasdf")
 	if err == nil {
 		t.Error("Should have through error unpinning known directory")
 	}
 }
+
+// Adds data without pinning it
+func addUnpinned(t *testing.T, cctx commands.Context, data []byte) string {
+	opts := DefaultAddOptions()
+	opts.Pin = false
+	hash, _, err := AddReader(context.Background(), cctx, bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// Returns the pins of type typ
+func pinsOfType(t *testing.T, cctx commands.Context, typ PinType) map[string]PinType {
+	pins, err := PinLs(context.Background(), cctx, typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]PinType)
+	for _, p := range pins {
+		if _, ok := out[p.Cid]; ok {
+			t.Errorf("%s is listed twice", p.Cid)
+		}
+		out[p.Cid] = p.Type
+	}
+	return out
+}
+
+func TestPinModes(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	small := addUnpinned(t, cctx, []byte("direct"))
+	large := addUnpinned(t, cctx, catData)
+
+	if err := PinAdd(ctx, cctx, small, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := PinAdd(ctx, cctx, small, false); !errors.Is(err, ErrAlreadyPinned) {
+		t.Errorf("Pinning twice returned %v", err)
+	}
+	if err := PinDir(ctx, cctx, large); err != nil {
+		t.Fatal(err)
+	}
+	// Unlike PinAdd, Pin and PinDir do nothing if it is pinned already
+	if err := PinDir(ctx, cctx, large); err != nil {
+		t.Errorf("Pinning a directory twice returned %v", err)
+	}
+	if err := Pin(ctx, cctx, large); err != nil {
+		t.Errorf("Pinning twice returned %v", err)
+	}
+
+	if pins := pinsOfType(t, cctx, PinDirect); len(pins) != 1 || pins[small] != PinDirect {
+		t.Errorf("Direct pins: %v", pins)
+	}
+	if pins := pinsOfType(t, cctx, PinRecursive); len(pins) != 1 || pins[large] != PinRecursive {
+		t.Errorf("Recursive pins: %v", pins)
+	}
+	indirect := pinsOfType(t, cctx, PinIndirect)
+	if len(indirect) < 2 {
+		t.Errorf("Indirect pins of a %d byte file: %v", len(catData), indirect)
+	}
+	all := pinsOfType(t, cctx, PinAll)
+	if len(all) != len(indirect)+2 {
+		t.Errorf("%d pins in total, want %d", len(all), len(indirect)+2)
+	}
+	if _, err := PinLs(ctx, cctx, "sideways"); err == nil {
+		t.Error("Listing an invalid pin type succeeded")
+	}
+
+	if err := PinRm(ctx, cctx, large, false); err == nil {
+		t.Error("Removed a recursive pin as a direct one")
+	}
+	if err := PinRm(ctx, cctx, small, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := PinRm(ctx, cctx, large, true); err != nil {
+		t.Fatal(err)
+	}
+	if pins := pinsOfType(t, cctx, PinAll); len(pins) != 0 {
+		t.Errorf("Pins left after removing all: %v", pins)
+	}
+}
+
+func TestPinAddSubPath(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := dag.NodeWithData(ft.FilePBData([]byte("file"), 4))
+	dir := addDagDir(t, cctx, dagEntry{"file", file}).Cid().String()
+	sub := "/ipfs/" + dir + "/file"
+
+	// The object at the end of the path is pinned, not the directory
+	if err := PinAdd(ctx, cctx, sub, true); err != nil {
+		t.Fatal(err)
+	}
+	if pins := pinsOfType(t, cctx, PinRecursive); len(pins) != 1 || pins[file.Cid().String()] != PinRecursive {
+		t.Errorf("Recursive pins: %v", pins)
+	}
+	if err := PinAdd(ctx, cctx, sub, true); !errors.Is(err, ErrAlreadyPinned) {
+		t.Errorf("Pinning a sub-path twice returned %v", err)
+	}
+	if err := PinAdd(ctx, cctx, file.Cid().String(), false); !errors.Is(err, ErrAlreadyPinned) {
+		t.Errorf("Pinning a recursively pinned object directly returned %v", err)
+	}
+
+	// The directory itself is not pinned yet
+	if err := PinAdd(ctx, cctx, dir, true); err != nil {
+		t.Errorf("Pinning the directory returned %v", err)
+	}
+}
+
+func TestPinUpdate(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "ipfs_pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Two versions of a directory sharing a file
+	opts := DefaultAddOptions()
+	opts.Pin = false
+	var roots [2]string
+	for i, content := range []string{"old", "new"} {
+		dir := filepath.Join(tmp, content)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "shared"), []byte("shared"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "own"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if roots[i], err = AddDirectoryWithOptions(ctx, cctx, dir, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shared := addUnpinned(t, cctx, []byte("shared"))
+
+	if err := PinUpdate(ctx, cctx, roots[0], roots[1], true); !errors.Is(err, ErrNotPinned) {
+		t.Errorf("Updating an unpinned root returned %v", err)
+	}
+	if err := Pin(ctx, cctx, roots[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := PinUpdate(ctx, cctx, roots[0], roots[1], true); err != nil {
+		t.Fatal(err)
+	}
+	pins := pinsOfType(t, cctx, PinAll)
+	if pins[roots[1]] != PinRecursive || pins[shared] != PinIndirect {
+		t.Errorf("Pins after the update: %v", pins)
+	}
+	if _, ok := pins[roots[0]]; ok {
+		t.Errorf("The old root is still pinned: %v", pins)
+	}
+}
+
+func TestPinVerify(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := addUnpinned(t, cctx, catData)
+	if err := Pin(ctx, cctx, root); err != nil {
+		t.Fatal(err)
+	}
+	status, err := PinVerify(ctx, cctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 1 || status[0].Cid != root || !status[0].Ok {
+		t.Fatalf("Verifying a complete pin: %+v", status)
+	}
+
+	nd, _ := cctx.GetNode()
+	c, _ := cid.Decode(root)
+	links, err := nd.DAG.GetLinks(ctx, c)
+	if err != nil || len(links) == 0 {
+		t.Fatalf("%d links, %v", len(links), err)
+	}
+	if err := nd.Blockstore.DeleteBlock(links[0].Cid); err != nil {
+		t.Fatal(err)
+	}
+	status, err = PinVerify(ctx, cctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 1 || status[0].Ok || len(status[0].Missing) != 1 || status[0].Missing[0] != links[0].Cid.String() {
+		t.Errorf("Verifying a pin missing %s: %+v", links[0].Cid, status)
+	}
+}
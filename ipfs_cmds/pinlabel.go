@@ -0,0 +1,330 @@
+package ipfs_cmds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/pin"
+
+	cid "gx/ipfs/QmNp85zy9RLrQ5oQD4hPyS39ezrrXpcaa7R4Y9kxdWQLLQ/go-cid"
+	"gx/ipfs/QmVSase1JP7cq9QkPT46oNwdp9pT6kBkG3oqS14y3QcZjG/go-datastore"
+	"gx/ipfs/QmVSase1JP7cq9QkPT46oNwdp9pT6kBkG3oqS14y3QcZjG/go-datastore/query"
+)
+
+// Datastore keys of labelled pins. Each owner of a CID has a record under
+// owners/<cid>/<owner>, roots/<cid> records whether the recursive pin was
+// made for them: "true", "false", or "direct" if it replaced a direct pin.
+const (
+	pinOwnersPrefix = "/ipfs_demo/pins/owners/"
+	pinRootsPrefix  = "/ipfs_demo/pins/roots/"
+)
+
+var errInvalidOwner = errors.New("Pin owners can not be empty, '.', '..' or contain '/'")
+
+var errOwnedPin = errors.New("The pin is held by labelled pin owners")
+
+// Locks serializing changes to the labelled pins of each repo datastore,
+// so that owners are counted right
+var labelledPinLocks = struct {
+	sync.Mutex
+	m map[datastore.Datastore]*sync.Mutex
+}{m: make(map[datastore.Datastore]*sync.Mutex)}
+
+// Returns the lock of the labelled pins of nd
+func labelledPinLock(nd *core.IpfsNode) *sync.Mutex {
+	ds := nd.Repo.Datastore()
+	labelledPinLocks.Lock()
+	defer labelledPinLocks.Unlock()
+	l, ok := labelledPinLocks.m[ds]
+	if !ok {
+		l = new(sync.Mutex)
+		labelledPinLocks.m[ds] = l
+	}
+	return l
+}
+
+// LabelledPin is the pin of a CID held by one owner. The CID stays
+// recursively pinned as long as it has an owner.
+type LabelledPin struct {
+	Cid     string
+	Owner   string
+	Label   string
+	Created time.Time
+	Expires time.Time // zero if the pin does not expire
+}
+
+// PinQuery selects labelled pins, empty fields match anything
+type PinQuery struct {
+	Cid   string
+	Owner string
+	Label string
+}
+
+func (q PinQuery) matches(p LabelledPin) bool {
+	return (q.Cid == "" || q.Cid == p.Cid) &&
+		(q.Owner == "" || q.Owner == p.Owner) &&
+		(q.Label == "" || q.Label == p.Label)
+}
+
+func pinOwnerKey(c, owner string) datastore.Key {
+	return datastore.NewKey(pinOwnersPrefix + c + "/" + owner)
+}
+
+func pinRootKey(c string) datastore.Key {
+	return datastore.NewKey(pinRootsPrefix + c)
+}
+
+func checkOwner(owner string) error {
+	// The owner is a segment of a datastore key, which is cleaned
+	if owner == "" || owner == "." || owner == ".." || strings.Contains(owner, "/") {
+		return errInvalidOwner
+	}
+	return nil
+}
+
+// Resolves hash, an IPFS path or hash, to the CID it is pinned under
+func resolveCid(ctx context.Context, nd *core.IpfsNode, hash string) (*cid.Cid, error) {
+	p, err := parsePath(hash)
+	if err != nil {
+		return nil, err
+	}
+	return core.ResolveToCid(ctx, nd.Namesys, nd.Resolver, p)
+}
+
+// Returns the labelled pins matching q, q.Cid is a CID if set
+func queryLabelledPins(nd *core.IpfsNode, q PinQuery) ([]LabelledPin, error) {
+	prefix := pinOwnersPrefix
+	if q.Cid != "" {
+		prefix += q.Cid + "/"
+	}
+	res, err := nd.Repo.Datastore().Query(query.Query{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+	var pins []LabelledPin
+	for _, e := range entries {
+		b, ok := e.Value.([]byte)
+		if !ok {
+			return nil, errors.New("Labelled pin record is not []byte")
+		}
+		var p LabelledPin
+		if err := json.Unmarshal(b, &p); err != nil {
+			return nil, err
+		}
+		if q.matches(p) {
+			pins = append(pins, p)
+		}
+	}
+	return pins, nil
+}
+
+// PinLabelled pins hash, an IPFS path or hash, recursively for owner. If
+// owner already pins it only the label and expiry are changed.
+// A CID pinned before its first owner is pinned like that again after the
+// last one. Removing that pin with PinRm while it has owners hands it over
+// to them instead, and PinRm refuses to remove the pin made for the owners.
+func PinLabelled(ctx context.Context, cctx commands.Context, hash, owner, label string, expires time.Time) (LabelledPin, error) {
+	return pinLabelled(ctx, cctx, hash, owner, label, func(*LabelledPin) time.Time { return expires })
+}
+
+// PinLabelled with the expiry computed from the current pin of owner, nil
+// if it has none, while holding the labelled pin lock
+func pinLabelled(ctx context.Context, cctx commands.Context, hash, owner, label string, expiry func(old *LabelledPin) time.Time) (_ LabelledPin, err error) {
+	defer wrapErr(&err, "pin", hash)
+	if err := checkOwner(owner); err != nil {
+		return LabelledPin{}, err
+	}
+	nd, err := cctx.GetNode()
+	if err != nil {
+		return LabelledPin{}, err
+	}
+	c, err := resolveCid(ctx, nd, hash)
+	if err != nil {
+		return LabelledPin{}, err
+	}
+	lock := labelledPinLock(nd)
+	lock.Lock()
+	defer lock.Unlock()
+	return addLabelledPin(ctx, cctx, nd, c, owner, label, expiry)
+}
+
+// Records the pin of the CID c held by owner and pins c for its first
+// owner. The caller holds the labelled pin lock of nd.
+func addLabelledPin(ctx context.Context, cctx commands.Context, nd *core.IpfsNode, c *cid.Cid, owner, label string, expiry func(old *LabelledPin) time.Time) (LabelledPin, error) {
+	ds := nd.Repo.Datastore()
+	owners, err := queryLabelledPins(nd, PinQuery{Cid: c.String()})
+	if err != nil {
+		return LabelledPin{}, err
+	}
+	p := LabelledPin{
+		Cid:     c.String(),
+		Owner:   owner,
+		Label:   label,
+		Created: time.Now().UTC(),
+	}
+	var old *LabelledPin
+	for i, o := range owners {
+		if o.Owner == owner {
+			p.Created = o.Created
+			old = &owners[i]
+		}
+	}
+	p.Expires = expiry(old)
+
+	// The first owner pins the CID, unless it already was pinned. Pinning
+	// a directly pinned CID recursively replaces the direct pin, which is
+	// restored with the last owner.
+	if len(owners) == 0 {
+		_, direct, err := nd.Pinning.IsPinnedWithType(c, pin.Direct)
+		if err != nil {
+			return LabelledPin{}, err
+		}
+		err = PinAdd(ctx, cctx, c.String(), true)
+		if err != nil && !errors.Is(err, ErrAlreadyPinned) {
+			return LabelledPin{}, err
+		}
+		pinned := []byte("true")
+		if err != nil {
+			pinned = []byte("false")
+		} else if direct {
+			pinned = []byte("direct")
+		}
+		if err := ds.Put(pinRootKey(c.String()), pinned); err != nil {
+			return LabelledPin{}, err
+		}
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return LabelledPin{}, err
+	}
+	if err := ds.Put(pinOwnerKey(p.Cid, owner), b); err != nil {
+		return LabelledPin{}, err
+	}
+	return p, nil
+}
+
+// Hands the pin of the CID c, which the plain pin API is about to remove,
+// over to its labelled owners. It returns false if c has no owners or the
+// pin is not theirs to take. The caller holds the labelled pin lock of nd.
+func takeOverPin(nd *core.IpfsNode, c string, recursive bool) (bool, error) {
+	owners, err := queryLabelledPins(nd, PinQuery{Cid: c})
+	if err != nil || len(owners) == 0 {
+		return false, err
+	}
+	ds := nd.Repo.Datastore()
+	pinned, err := ds.Get(pinRootKey(c))
+	if err != nil && err != datastore.ErrNotFound {
+		return false, err
+	}
+	b, _ := pinned.([]byte)
+	switch {
+	case recursive && string(b) == "false", !recursive && string(b) == "direct":
+		// The owners pin c from now on and unpin it with the last of them
+		return true, ds.Put(pinRootKey(c), []byte("true"))
+	case recursive:
+		return true, errOwnedPin
+	}
+	return false, nil
+}
+
+// UnpinLabelled releases the pin of hash held by owner. The recursive pin
+// is removed with the last owner, if it was made for the owners.
+func UnpinLabelled(ctx context.Context, cctx commands.Context, hash, owner string) (err error) {
+	defer wrapErr(&err, "unpin", hash)
+	if err := checkOwner(owner); err != nil {
+		return err
+	}
+	nd, err := cctx.GetNode()
+	if err != nil {
+		return err
+	}
+	c, err := resolveCid(ctx, nd, hash)
+	if err != nil {
+		return err
+	}
+	lock := labelledPinLock(nd)
+	lock.Lock()
+	defer lock.Unlock()
+	return releaseLabelledPin(ctx, cctx, nd, c.String(), owner)
+}
+
+// Deletes the record of owner for the CID c and removes the recursive pin
+// with the last owner, restoring a direct pin it replaced. The caller holds
+// the labelled pin lock of nd.
+func releaseLabelledPin(ctx context.Context, cctx commands.Context, nd *core.IpfsNode, c, owner string) error {
+	ds := nd.Repo.Datastore()
+	key := pinOwnerKey(c, owner)
+	if has, err := ds.Has(key); err != nil {
+		return err
+	} else if !has {
+		return ErrNotPinned
+	}
+	if err := ds.Delete(key); err != nil {
+		return err
+	}
+	owners, err := queryLabelledPins(nd, PinQuery{Cid: c})
+	if err != nil || len(owners) > 0 {
+		return err
+	}
+
+	pinned, err := ds.Get(pinRootKey(c))
+	if err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+	if b, _ := pinned.([]byte); string(b) == "true" || string(b) == "direct" {
+		err := pinRm(ctx, nd, c, true)
+		if err != nil && !errors.Is(err, ErrNotPinned) {
+			return err
+		}
+		if string(b) == "direct" {
+			err := PinAdd(ctx, cctx, c, false)
+			if err != nil && !errors.Is(err, ErrAlreadyPinned) {
+				return err
+			}
+		}
+	}
+	if err := ds.Delete(pinRootKey(c)); err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// ListLabelledPins returns the labelled pins matching q, sorted by CID
+// and owner
+func ListLabelledPins(ctx context.Context, cctx commands.Context, q PinQuery) (_ []LabelledPin, err error) {
+	defer wrapErr(&err, "pin ls", q.Label)
+	nd, err := cctx.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	if q.Cid != "" {
+		c, err := resolveCid(ctx, nd, q.Cid)
+		if err != nil {
+			return nil, err
+		}
+		q.Cid = c.String()
+	}
+	out, err := queryLabelledPins(nd, q)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Cid != out[j].Cid {
+			return out[i].Cid < out[j].Cid
+		}
+		return out[i].Owner < out[j].Owner
+	})
+	return out, nil
+}
@@ -0,0 +1,210 @@
+package ipfs_cmds
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/commands"
+)
+
+// Reports whether hash is recursively pinned
+func isPinned(t *testing.T, cctx commands.Context, hash string) bool {
+	_, ok := pinsOfType(t, cctx, PinRecursive)[hash]
+	return ok
+}
+
+func TestLabelledPinOwners(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := addUnpinned(t, cctx, []byte("shared by two owners"))
+
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	a, err := PinLabelled(ctx, cctx, hash, "indexer", "search", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Cid != hash || a.Owner != "indexer" || a.Label != "search" || !a.Expires.Equal(expires) || a.Created.IsZero() {
+		t.Errorf("Pinned %+v", a)
+	}
+	if _, err := PinLabelled(ctx, cctx, "/ipfs/"+hash, "backup", "nightly", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	relabelled, err := PinLabelled(ctx, cctx, hash, "indexer", "archive", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !relabelled.Created.Equal(a.Created) || relabelled.Label != "archive" || !relabelled.Expires.IsZero() {
+		t.Errorf("Pinning again changed %+v to %+v", a, relabelled)
+	}
+
+	pins, err := ListLabelledPins(ctx, cctx, PinQuery{Cid: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 2 || pins[0].Owner != "backup" || pins[1].Owner != "indexer" {
+		t.Errorf("Owners of %s: %+v", hash, pins)
+	}
+	if pins, _ := ListLabelledPins(ctx, cctx, PinQuery{Label: "nightly"}); len(pins) != 1 || pins[0].Owner != "backup" {
+		t.Errorf("Pins labelled nightly: %+v", pins)
+	}
+
+	// Released with the last owner only
+	if err := UnpinLabelled(ctx, cctx, hash, "indexer"); err != nil {
+		t.Fatal(err)
+	}
+	if !isPinned(t, cctx, hash) {
+		t.Error("Unpinned while another owner holds it")
+	}
+	if err := UnpinLabelled(ctx, cctx, hash, "indexer"); !errors.Is(err, ErrNotPinned) {
+		t.Errorf("Unpinning twice returned %v", err)
+	}
+	if err := UnpinLabelled(ctx, cctx, hash, "backup"); err != nil {
+		t.Fatal(err)
+	}
+	if isPinned(t, cctx, hash) {
+		t.Error("Still pinned after the last owner unpinned it")
+	}
+	if pins, _ := ListLabelledPins(ctx, cctx, PinQuery{}); len(pins) != 0 {
+		t.Errorf("Labelled pins left: %+v", pins)
+	}
+
+	for _, owner := range []string{"", "a/b", ".", ".."} {
+		if _, err := PinLabelled(ctx, cctx, hash, owner, "", time.Time{}); !errors.Is(err, errInvalidOwner) {
+			t.Errorf("Pinning for the invalid owner %q returned %v", owner, err)
+		}
+		if err := UnpinLabelled(ctx, cctx, hash, owner); !errors.Is(err, errInvalidOwner) {
+			t.Errorf("Unpinning for the invalid owner %q returned %v", owner, err)
+		}
+	}
+}
+
+func TestLabelledPinKeepsPlainPin(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := addUnpinned(t, cctx, []byte("pinned before"))
+	if err := Pin(ctx, cctx, hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PinLabelled(ctx, cctx, hash, "app", "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnpinLabelled(ctx, cctx, hash, "app"); err != nil {
+		t.Fatal(err)
+	}
+	if !isPinned(t, cctx, hash) {
+		t.Error("Releasing a labelled pin removed a pin made without a label")
+	}
+}
+
+func TestLabelledPinKeepsDirectPin(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := addUnpinned(t, cctx, catData)
+	if err := PinAdd(ctx, cctx, hash, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PinLabelled(ctx, cctx, hash, "app", "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if pins := pinsOfType(t, cctx, PinRecursive); pins[hash] != PinRecursive {
+		t.Errorf("A labelled pin of a directly pinned CID is not recursive: %v", pins)
+	}
+	if err := UnpinLabelled(ctx, cctx, hash, "app"); err != nil {
+		t.Fatal(err)
+	}
+	if pins := pinsOfType(t, cctx, PinDirect); pins[hash] != PinDirect {
+		t.Errorf("Releasing a labelled pin lost the direct pin: %v", pins)
+	}
+	if pins := pinsOfType(t, cctx, PinRecursive); len(pins) != 0 {
+		t.Errorf("Releasing a labelled pin left recursive pins: %v", pins)
+	}
+}
+
+func TestPlainUnpinOfLabelledPin(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unpinning a plain pin taken over by owners keeps it for them
+	plain := addUnpinned(t, cctx, []byte("pinned before"))
+	if err := Pin(ctx, cctx, plain); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PinLabelled(ctx, cctx, plain, "app", "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnPinDir(ctx, cctx, plain); err != nil {
+		t.Fatal(err)
+	}
+	if !isPinned(t, cctx, plain) {
+		t.Error("Plain unpin removed a pin held by an owner")
+	}
+	if err := UnpinLabelled(ctx, cctx, plain, "app"); err != nil {
+		t.Fatal(err)
+	}
+	if isPinned(t, cctx, plain) {
+		t.Error("Pin handed over to the owners outlived them")
+	}
+
+	// Same with the direct pin a labelled pin replaced
+	direct := addUnpinned(t, cctx, []byte("pinned directly"))
+	if err := PinAdd(ctx, cctx, direct, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PinLabelled(ctx, cctx, direct, "app", "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := PinRm(ctx, cctx, direct, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnpinLabelled(ctx, cctx, direct, "app"); err != nil {
+		t.Fatal(err)
+	}
+	if pins := pinsOfType(t, cctx, PinAll); len(pins) != 0 {
+		t.Errorf("Pins left after releasing the handed over pins: %v", pins)
+	}
+
+	// The pin made for the owners can't be removed around them
+	owned := addUnpinned(t, cctx, []byte("owned"))
+	if _, err := PinLabelled(ctx, cctx, owned, "app", "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnPinDir(ctx, cctx, owned); !errors.Is(err, errOwnedPin) {
+		t.Errorf("Plain unpin of an owned pin returned %v", err)
+	}
+	if !isPinned(t, cctx, owned) {
+		t.Error("Plain unpin removed the pin of an owner")
+	}
+}
+
+func TestLabelledPinLockPerNode(t *testing.T) {
+	a, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nda, _ := a.GetNode()
+	ndb, _ := b.GetNode()
+	if labelledPinLock(nda) != labelledPinLock(nda) {
+		t.Error("A node has several labelled pin locks")
+	}
+	if labelledPinLock(nda) == labelledPinLock(ndb) {
+		t.Error("Nodes share their labelled pin lock")
+	}
+}
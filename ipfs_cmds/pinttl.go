@@ -0,0 +1,74 @@
+package ipfs_cmds
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ipfs/go-ipfs/commands"
+)
+
+// TTLPinOwner is the owner of the labelled pins made by PinWithTTL
+const TTLPinOwner = "ttl"
+
+var errInvalidTTL = errors.New("Pin TTL must be positive")
+
+// Clock tells the time pins expire at
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now().UTC() }
+
+// SystemClock is the clock of the system
+var SystemClock Clock = systemClock{}
+
+// PinWithTTL pins hash, an IPFS path or hash, recursively until ttl from
+// the time of clock. All TTL pins share one owner, so pinning a CID again
+// before it is swept extends its pin to the later of both expiries and
+// never shortens it. The expiry is stored in the repo, so it survives
+// restarts.
+func PinWithTTL(ctx context.Context, cctx commands.Context, clock Clock, hash string, ttl time.Duration) (LabelledPin, error) {
+	if ttl <= 0 {
+		return LabelledPin{}, newError("pin", hash, errInvalidTTL)
+	}
+	expires := clock.Now().Add(ttl).UTC()
+	return pinLabelled(ctx, cctx, hash, TTLPinOwner, "", func(old *LabelledPin) time.Time {
+		if old != nil && old.Expires.After(expires) {
+			return old.Expires
+		}
+		return expires
+	})
+}
+
+// SweepExpiredPins releases the labelled pins of any owner which expired
+// at or before now and returns them. Their blocks are left to the garbage
+// collector.
+func SweepExpiredPins(ctx context.Context, cctx commands.Context, now time.Time) (_ []LabelledPin, err error) {
+	defer wrapErr(&err, "pin sweep", "")
+	nd, err := cctx.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	lock := labelledPinLock(nd)
+	lock.Lock()
+	defer lock.Unlock()
+
+	pins, err := queryLabelledPins(nd, PinQuery{})
+	if err != nil {
+		return nil, err
+	}
+	var swept []LabelledPin
+	for _, p := range pins {
+		if p.Expires.IsZero() || p.Expires.After(now) {
+			continue
+		}
+		if err := releaseLabelledPin(ctx, cctx, nd, p.Cid, p.Owner); err != nil {
+			return swept, err
+		}
+		swept = append(swept, p)
+	}
+	return swept, nil
+}
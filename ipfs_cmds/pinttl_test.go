@@ -0,0 +1,105 @@
+package ipfs_cmds
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPinWithTTL(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewMockClock(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	cached := addUnpinned(t, cctx, []byte("cached third-party content"))
+	kept := addUnpinned(t, cctx, []byte("also pinned by an owner"))
+
+	p, err := PinWithTTL(ctx, cctx, clock, cached, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Owner != TTLPinOwner || !p.Expires.Equal(clock.Now().Add(time.Hour)) {
+		t.Errorf("Pinned %+v", p)
+	}
+	if _, err := PinWithTTL(ctx, cctx, clock, kept, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PinLabelled(ctx, cctx, kept, "indexer", "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renewed before it expired
+	clock.Add(time.Minute * 30)
+	if _, err := PinWithTTL(ctx, cctx, clock, cached, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	clock.Add(time.Minute * 45)
+	swept, err := SweepExpiredPins(ctx, cctx, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(swept) != 1 || swept[0].Cid != kept {
+		t.Errorf("Swept %+v, expected the TTL pin of %s", swept, kept)
+	}
+	if !isPinned(t, cctx, cached) || !isPinned(t, cctx, kept) {
+		t.Error("Released a pin which did not expire or has another owner")
+	}
+
+	clock.Add(time.Minute * 30)
+	swept, err = SweepExpiredPins(ctx, cctx, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(swept) != 1 || swept[0].Cid != cached {
+		t.Errorf("Swept %+v, expected %s", swept, cached)
+	}
+	if isPinned(t, cctx, cached) {
+		t.Error("Still pinned after it expired")
+	}
+	if pins, _ := ListLabelledPins(ctx, cctx, PinQuery{Owner: TTLPinOwner}); len(pins) != 0 {
+		t.Errorf("TTL pins left: %+v", pins)
+	}
+
+	if _, err := PinWithTTL(ctx, cctx, clock, cached, 0); !errors.Is(err, errInvalidTTL) {
+		t.Errorf("Pinning with a zero TTL returned %v", err)
+	}
+}
+
+func TestPinWithTTLKeepsLaterExpiry(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewMockClock(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	hash := addUnpinned(t, cctx, []byte("cached by two callers"))
+	expires := clock.Now().Add(time.Hour * 2)
+
+	if _, err := PinWithTTL(ctx, cctx, clock, hash, time.Hour*2); err != nil {
+		t.Fatal(err)
+	}
+	// A shorter TTL does not cut the pin short
+	p, err := PinWithTTL(ctx, cctx, clock, hash, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Expires.Equal(expires) {
+		t.Errorf("Shorter TTL moved the expiry to %s, expected %s", p.Expires, expires)
+	}
+	clock.Add(time.Minute * 90)
+	if swept, err := SweepExpiredPins(ctx, cctx, clock.Now()); err != nil || len(swept) != 0 {
+		t.Errorf("Swept %+v before the longer TTL ended: %v", swept, err)
+	}
+
+	// A longer one extends it
+	p, err = PinWithTTL(ctx, cctx, clock, hash, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expires = clock.Now().Add(time.Hour); !p.Expires.Equal(expires) {
+		t.Errorf("Longer TTL set the expiry to %s, expected %s", p.Expires, expires)
+	}
+}
@@ -67,12 +67,12 @@ func NewPointer(mhKey multihash.Multihash, prefixLen int, addr ma.Multiaddr, ent
 	return Pointer{Cid: k, Value: pi}, nil
 }
 
-func PublishPointer(node *core.IpfsNode, ctx context.Context, pointer Pointer) error {
-	return addPointer(node, ctx, pointer.Cid, pointer.Value)
+func PublishPointer(ctx context.Context, node *core.IpfsNode, pointer Pointer) error {
+	return addPointer(ctx, node, pointer.Cid, pointer.Value)
 }
 
 // Fetch pointers from the dht. They will be returned asynchronously.
-func FindPointersAsync(dht *routing.IpfsDHT, ctx context.Context, mhKey multihash.Multihash, prefixLen int) <-chan ps.PeerInfo {
+func FindPointersAsync(ctx context.Context, dht *routing.IpfsDHT, mhKey multihash.Multihash, prefixLen int) <-chan ps.PeerInfo {
 	keyhash := CreatePointerKey(mhKey, prefixLen)
 	key, _ := cid.Decode(keyhash.B58String())
 	peerout := dht.FindProvidersAsync(ctx, key, 100000)
@@ -80,20 +80,20 @@ func FindPointersAsync(dht *routing.IpfsDHT, ctx context.Context, mhKey multihas
 }
 
 // Fetch pointers from the dht
-func FindPointers(dht *routing.IpfsDHT, ctx context.Context, mhKey multihash.Multihash, prefixLen int) ([]ps.PeerInfo, error) {
+func FindPointers(ctx context.Context, dht *routing.IpfsDHT, mhKey multihash.Multihash, prefixLen int) ([]ps.PeerInfo, error) {
 	var providers []ps.PeerInfo
-	for p := range FindPointersAsync(dht, ctx, mhKey, prefixLen) {
+	for p := range FindPointersAsync(ctx, dht, mhKey, prefixLen) {
 		providers = append(providers, p)
 	}
 	return providers, nil
 }
 
-func PutPointerToPeer(node *core.IpfsNode, ctx context.Context, peer peer.ID, pointer Pointer) error {
+func PutPointerToPeer(ctx context.Context, node *core.IpfsNode, peer peer.ID, pointer Pointer) error {
 	dht := node.Routing.(*routing.IpfsDHT)
 	return putPointer(ctx, dht, peer, pointer.Value, pointer.Cid.KeyString())
 }
 
-func GetPointersFromPeer(node *core.IpfsNode, ctx context.Context, p peer.ID, key *cid.Cid) ([]*ps.PeerInfo, error) {
+func GetPointersFromPeer(ctx context.Context, node *core.IpfsNode, p peer.ID, key *cid.Cid) ([]*ps.PeerInfo, error) {
 	dht := node.Routing.(*routing.IpfsDHT)
 	pmes := pb.NewMessage(pb.Message_GET_PROVIDERS, key.KeyString(), 0)
 	resp, err := dht.SendRequest(ctx, p, pmes)
@@ -103,7 +103,7 @@ func GetPointersFromPeer(node *core.IpfsNode, ctx context.Context, p peer.ID, ke
 	return dhtpb.PBPeersToPeerInfos(resp.GetProviderPeers()), nil
 }
 
-func addPointer(node *core.IpfsNode, ctx context.Context, k *cid.Cid, pi ps.PeerInfo) error {
+func addPointer(ctx context.Context, node *core.IpfsNode, k *cid.Cid, pi ps.PeerInfo) error {
 	dht := node.Routing.(*routing.IpfsDHT)
 	peers, err := dht.GetClosestPeers(ctx, k.KeyString())
 	if err != nil {
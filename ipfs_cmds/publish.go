@@ -1,32 +1,57 @@
 package ipfs_cmds
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/ipfs/go-ipfs/commands"
-	coreCmds "github.com/ipfs/go-ipfs/core/commands"
+	"github.com/ipfs/go-ipfs/core"
 )
 
-var pubErr = errors.New(`Name publish failed`)
+// How long published IPNS records are valid
+const recordLifetime = time.Hour * 24
 
 // Publish a signed IPNS record to our Peer ID
-func Publish(ctx commands.Context, hash string) (string, error) {
-	args := []string{"name", "publish", "/ipfs/" + hash}
-	req, cmd, err := NewRequest(ctx, args)
+func Publish(ctx context.Context, cctx commands.Context, hash string) (string, error) {
+	return PublishWithKey(ctx, cctx, SelfKey, hash)
+}
+
+// Publish a signed IPNS record to the name of the key called key. The key
+// may also be given by its peer ID.
+func PublishWithKey(ctx context.Context, cctx commands.Context, key string, hash string) (_ string, err error) {
+	defer wrapErr(&err, "publish", hash)
+	nd, err := nameNode(cctx)
 	if err != nil {
 		return "", err
 	}
-	res := commands.NewResponse(req)
-	cmd.Run(req, res)
-	resp := res.Output()
-	if res.Error() != nil {
-		log.Error(res.Error())
-		return "", res.Error()
+	if nd.Mounts.Ipns != nil && nd.Mounts.Ipns.IsActive() {
+		return "", errors.New("cannot manually publish while IPNS is mounted")
 	}
-	returnedVal := resp.(*coreCmds.IpnsEntry).Value
-	if returnedVal != "/ipfs/"+hash {
-		return "", pubErr
+	if nd.Identity == "" {
+		return "", errors.New("identity not loaded")
+	}
+
+	sk, err := lookupKey(nd, key)
+	if err != nil {
+		log.Error(err)
+		return "", err
 	}
-	log.Infof("Published %s to IPNS", hash)
-	return returnedVal, nil
+	ref, err := parsePath("/ipfs/" + hash)
+	if err != nil {
+		return "", err
+	}
+
+	// Make sure the path exists before publishing it
+	if _, err := core.Resolve(ctx, nd.Namesys, nd.Resolver, ref); err != nil {
+		log.Error(err)
+		return "", err
+	}
+	if err := nd.Namesys.PublishWithEOL(ctx, sk, ref, time.Now().Add(recordLifetime)); err != nil {
+		log.Error(err)
+		return "", err
+	}
+
+	log.Infof("Published %s to IPNS with key %s", hash, key)
+	return ref.String(), nil
 }
@@ -0,0 +1,449 @@
+package ipfs_cmds
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/merkledag"
+
+	inet "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	cid "gx/ipfs/QmNp85zy9RLrQ5oQD4hPyS39ezrrXpcaa7R4Y9kxdWQLLQ/go-cid"
+	blocks "gx/ipfs/QmSn9Td7xgxm9EV7iEjTckpUWmWApggzPxu7eFGWkkpwin/go-block-format"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+)
+
+// PushProtocol is the stream protocol DAGs are pushed to other nodes with.
+// The pusher offers the CIDs of the blocks below its roots in batches, the
+// node answers each batch with those it is missing, which are then sent one
+// by one. After the last batch the node checks that the offer is exactly the DAG below the roots, pins the
+// roots for the pusher and releases the roots it pushed before. Messages
+// are JSON, prefixed with their length as a uvarint.
+const PushProtocol protocol.ID = "/ipfs_demo/push/1.0.0"
+
+const (
+	maxPushMsgSize   = 16 << 20
+	pushServeTimeout = time.Minute * 10
+	pushPinLabel     = "push"
+)
+
+// CIDs offered at once
+var pushOfferBatch = 1024
+
+var (
+	errPushTooLarge = errors.New("Push message too large")
+	errPushProtocol = errors.New("Unexpected push message")
+	errPushDenied   = errors.New("Peer is not allowed to push")
+	errPushQuota    = errors.New("Push exceeds the quota of the peer")
+	errPushDag      = errors.New("Push offer is not the DAG below its roots")
+)
+
+// ServePushOptions controls who may push to a node and how much
+type ServePushOptions struct {
+	// Peers allowed to push, nobody if empty
+	AcceptFrom []peer.ID
+	// Limits of the DAGs each peer has pinned by its last push, counting
+	// the blocks the node stored already. Zero means no limit.
+	MaxBlocks int
+	MaxBytes  int64
+}
+
+// Sent by the pusher for each batch. All batches together hold every block
+// below the roots, the roots included.
+type pushOffer struct {
+	Roots []string // set in the first batch only
+	Cids  []string
+	Last  bool
+}
+
+// The answer to each pushOffer
+type pushWant struct {
+	Cids []string // in the order they were offered
+}
+
+// Sent by the pusher for each wanted CID
+type pushBlock struct {
+	Cid  string
+	Data []byte
+}
+
+// The answer once the wanted blocks are stored
+type pushDone struct {
+	Err string
+}
+
+// PushResult is the outcome of a successful push
+type PushResult struct {
+	Blocks int   // sent because the node was missing them
+	Bytes  int64 // of the blocks sent
+}
+
+func writePushMsg(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(b))
+	buf = append(buf[:binary.PutUvarint(buf, uint64(len(b)))], b...)
+	_, err = w.Write(buf)
+	return err
+}
+
+func readPushMsg(r *bufio.Reader, v interface{}) error {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if size > maxPushMsgSize {
+		return errPushTooLarge
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Owner of the labelled pins made for the pusher p
+func pushOwner(p peer.ID) string {
+	return "push:" + p.Pretty()
+}
+
+// Push sends the DAGs below roots, IPFS paths or hashes, to the node p runs
+// and has it pin them in place of the roots pushed before. Only the blocks
+// p is missing are sent, they must all be stored locally. p must serve
+// PushProtocol, see ServePush.
+func Push(ctx context.Context, cctx commands.Context, p peer.ID, roots []string) (_ PushResult, err error) {
+	defer wrapErr(&err, "push", p.Pretty())
+	nd, err := onlineNode(cctx)
+	if err != nil {
+		return PushResult{}, err
+	}
+
+	var rootCids []string
+	set := cid.NewSet()
+	getLinks := nd.DAG.GetOfflineLinkService().GetLinks
+	for _, root := range roots {
+		c, err := resolveCid(ctx, nd, root)
+		if err != nil {
+			return PushResult{}, err
+		}
+		if !set.Visit(c) {
+			continue
+		}
+		rootCids = append(rootCids, c.String())
+		if err := merkledag.EnumerateChildren(ctx, getLinks, c, set.Visit); err != nil {
+			return PushResult{}, err
+		}
+	}
+
+	s, err := nd.PeerHost.NewStream(ctx, p, PushProtocol)
+	if err != nil {
+		return PushResult{}, err
+	}
+	defer s.Close()
+	// Unblock reads and writes once ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Reset()
+		case <-done:
+		}
+	}()
+	r := bufio.NewReader(s)
+
+	var res PushResult
+	keys := set.Keys()
+	for start := 0; ; start += pushOfferBatch {
+		end := start + pushOfferBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		offer := pushOffer{Last: end == len(keys)}
+		if start == 0 {
+			offer.Roots = rootCids
+		}
+		for _, c := range keys[start:end] {
+			offer.Cids = append(offer.Cids, c.String())
+		}
+		if err := sendPushBatch(nd, s, r, offer, &res); err != nil {
+			return res, err
+		}
+		if offer.Last {
+			break
+		}
+	}
+	var pushed pushDone
+	if err := readPushMsg(r, &pushed); err != nil {
+		return res, err
+	}
+	if pushed.Err != "" {
+		return res, fmt.Errorf("Push node: %s", pushed.Err)
+	}
+	return res, nil
+}
+
+// Sends offer on s and the blocks the node wants of it
+func sendPushBatch(nd *core.IpfsNode, s inet.Stream, r *bufio.Reader, offer pushOffer, res *PushResult) error {
+	if err := writePushMsg(s, offer); err != nil {
+		return err
+	}
+	var want pushWant
+	if err := readPushMsg(r, &want); err != nil {
+		return err
+	}
+	offered := make(map[string]bool)
+	for _, c := range offer.Cids {
+		offered[c] = true
+	}
+	for _, w := range want.Cids {
+		if !offered[w] {
+			return errPushProtocol
+		}
+		c, err := cid.Decode(w)
+		if err != nil {
+			return err
+		}
+		blk, err := nd.Blockstore.Get(c)
+		if err != nil {
+			return err
+		}
+		if err := writePushMsg(s, pushBlock{Cid: w, Data: blk.RawData()}); err != nil {
+			return err
+		}
+		res.Blocks++
+		res.Bytes += int64(len(blk.RawData()))
+	}
+	return nil
+}
+
+// ServePush lets the nodes of opts.AcceptFrom push DAGs to this one with
+// Push. The roots each node pushed last stay pinned, labelled "push" and
+// owned by "push:<peer ID>". Pushes are received until the node is closed.
+func ServePush(ctx context.Context, cctx commands.Context, opts ServePushOptions) (err error) {
+	defer wrapErr(&err, "serve push", "")
+	nd, err := onlineNode(cctx)
+	if err != nil {
+		return err
+	}
+	// Pushes of one peer are received one at a time, a later one waits
+	// for the earlier to be pinned so that it replaces its roots
+	accept := make(map[peer.ID]*sync.Mutex)
+	for _, p := range opts.AcceptFrom {
+		accept[p] = new(sync.Mutex)
+	}
+	nd.PeerHost.SetStreamHandler(PushProtocol, func(s inet.Stream) {
+		lock, ok := accept[s.Conn().RemotePeer()]
+		if !ok {
+			log.Warningf("Refused push from %s: %s", s.Conn().RemotePeer().Pretty(), errPushDenied)
+			s.Reset()
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if err := receivePush(ctx, cctx, nd, s, opts); err != nil {
+			log.Warningf("Push from %s failed: %s", s.Conn().RemotePeer().Pretty(), err)
+			s.Reset()
+			return
+		}
+		s.Close()
+	})
+	return nil
+}
+
+// Handles a push on s, the errors it returns are those of the stream.
+// Blocks stored before the push fails are left to the garbage collector.
+func receivePush(ctx context.Context, cctx commands.Context, nd *core.IpfsNode, s inet.Stream, opts ServePushOptions) error {
+	s.SetDeadline(time.Now().Add(pushServeTimeout))
+	r := bufio.NewReader(s)
+
+	var roots []string
+	offered := make(map[string]bool)
+	var size int64
+	for first := true; ; first = false {
+		var offer pushOffer
+		if err := readPushMsg(r, &offer); err != nil {
+			return err
+		}
+		if first {
+			roots = offer.Roots
+		} else if len(offer.Roots) > 0 {
+			return errPushProtocol
+		}
+		if err := receivePushBatch(nd, s, r, opts, offer, offered, &size); err != nil {
+			return err
+		}
+		if offer.Last {
+			break
+		}
+	}
+	// Roots outside of the offer would be fetched from the network
+	for _, root := range roots {
+		if !offered[root] {
+			return errPushProtocol
+		}
+	}
+
+	// Nothing is fetched from the network. Blocks collected meanwhile fail
+	// the check and are sent again by the next push.
+	var done pushDone
+	err := checkPushedDag(ctx, nd, roots, offered)
+	if err == nil {
+		err = pinPushed(ctx, cctx, s.Conn().RemotePeer(), roots)
+	}
+	if err != nil {
+		done.Err = err.Error()
+	}
+	return writePushMsg(s, done)
+}
+
+// Answers one batch of a push on s and stores the blocks sent for it.
+// offered and size keep count of the blocks offered by all batches so far.
+func receivePushBatch(nd *core.IpfsNode, s inet.Stream, r *bufio.Reader, opts ServePushOptions, offer pushOffer, offered map[string]bool, size *int64) error {
+	var want pushWant
+	var wanted []*cid.Cid
+	for _, o := range offer.Cids {
+		c, err := cid.Decode(o)
+		if err != nil {
+			return err
+		}
+		if offered[c.String()] {
+			continue
+		}
+		if offered[c.String()] = true; opts.MaxBlocks > 0 && len(offered) > opts.MaxBlocks {
+			return errPushQuota
+		}
+		blk, err := nd.Blockstore.Get(c)
+		if err == nil {
+			*size += int64(len(blk.RawData()))
+		} else if err == blockstore.ErrNotFound {
+			want.Cids = append(want.Cids, c.String())
+			wanted = append(wanted, c)
+		} else {
+			return err
+		}
+	}
+	if opts.MaxBytes > 0 && *size > opts.MaxBytes {
+		return errPushQuota
+	}
+	if err := writePushMsg(s, want); err != nil {
+		return err
+	}
+
+	for _, c := range wanted {
+		var b pushBlock
+		if err := readPushMsg(r, &b); err != nil {
+			return err
+		}
+		if b.Cid != c.String() {
+			return errPushProtocol
+		}
+		if *size += int64(len(b.Data)); opts.MaxBytes > 0 && *size > opts.MaxBytes {
+			return errPushQuota
+		}
+		if sum, err := c.Prefix().Sum(b.Data); err != nil {
+			return err
+		} else if !sum.Equals(c) {
+			return blocks.ErrWrongHash
+		}
+		blk, err := blocks.NewBlockWithCid(b.Data, c)
+		if err != nil {
+			return err
+		}
+		if _, err := nd.Blocks.AddBlock(blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks that the blocks below roots are stored locally and are exactly
+// those offered, so that the quota covers everything pinned for the pusher
+// and nothing unrelated is stored
+func checkPushedDag(ctx context.Context, nd *core.IpfsNode, roots []string, offered map[string]bool) error {
+	set := cid.NewSet()
+	getLinks := nd.DAG.GetOfflineLinkService().GetLinks
+	for _, root := range roots {
+		c, err := cid.Decode(root)
+		if err != nil {
+			return err
+		}
+		if !set.Visit(c) {
+			continue
+		}
+		if err := merkledag.EnumerateChildren(ctx, getLinks, c, set.Visit); err != nil {
+			return err
+		}
+	}
+	if set.Len() != len(offered) {
+		return errPushDag
+	}
+	for _, c := range set.Keys() {
+		if !offered[c.String()] {
+			return errPushDag
+		}
+		// Raw leaves are enumerated without being read
+		if has, err := nd.Blockstore.Has(c); err != nil {
+			return err
+		} else if !has {
+			return blockstore.ErrNotFound
+		}
+	}
+	return nil
+}
+
+// Pins roots for the pusher p and releases those it pushed before. The
+// labelled pin lock is held throughout, so the pins of p are never seen
+// half replaced.
+func pinPushed(ctx context.Context, cctx commands.Context, p peer.ID, roots []string) error {
+	nd, err := cctx.GetNode()
+	if err != nil {
+		return err
+	}
+	var cids []*cid.Cid
+	for _, root := range roots {
+		c, err := resolveCid(ctx, nd, root)
+		if err != nil {
+			return err
+		}
+		cids = append(cids, c)
+	}
+	lock := labelledPinLock(nd)
+	lock.Lock()
+	defer lock.Unlock()
+
+	owner := pushOwner(p)
+	keep := make(map[string]bool)
+	for _, c := range cids {
+		_, err := addLabelledPin(ctx, cctx, nd, c, owner, pushPinLabel, func(*LabelledPin) time.Time { return time.Time{} })
+		if err != nil {
+			return err
+		}
+		keep[c.String()] = true
+	}
+	old, err := queryLabelledPins(nd, PinQuery{Owner: owner})
+	if err != nil {
+		return err
+	}
+	for _, pin := range old {
+		if keep[pin.Cid] {
+			continue
+		}
+		if err := releaseLabelledPin(ctx, cctx, nd, pin.Cid, owner); err != nil && !errors.Is(err, ErrNotPinned) {
+			return err
+		}
+	}
+	return nil
+}
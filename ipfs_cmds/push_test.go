@@ -0,0 +1,289 @@
+package ipfs_cmds
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/commands"
+	"github.com/ipfs/go-ipfs/core"
+
+	cid "gx/ipfs/QmNp85zy9RLrQ5oQD4hPyS39ezrrXpcaa7R4Y9kxdWQLLQ/go-cid"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+)
+
+// Adds data in 100 byte blocks
+func addChunked(t *testing.T, cctx commands.Context, data []byte) string {
+	opts := DefaultAddOptions()
+	opts.Chunker = "size-100"
+	hash, _, err := AddReader(context.Background(), cctx, bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestPush(t *testing.T) {
+	ctx := context.Background()
+	a, actx := startNode(t)
+	defer a.Close()
+	b, bctx := startNode(t)
+	defer b.Close()
+	if err := ConnectTo(ctx, actx, dialAddr(t, b)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not served yet
+	first := addChunked(t, actx, bytes.Repeat([]byte("a"), 1000))
+	if _, err := Push(ctx, actx, b.Identity, []string{first}); err == nil {
+		t.Fatal("Pushed to a node which does not serve pushes")
+	}
+	if err := ServePush(ctx, bctx, ServePushOptions{AcceptFrom: []peer.ID{a.Identity}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 leaves which are alike, and the root
+	res, err := Push(ctx, actx, b.Identity, []string{"/ipfs/" + first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Blocks != 2 {
+		t.Errorf("Pushed %d blocks, expected 2", res.Blocks)
+	}
+	if !isPinned(t, bctx, first) {
+		t.Error("Pushed root is not pinned")
+	}
+	res, err = Push(ctx, actx, b.Identity, []string{first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Blocks != 0 {
+		t.Errorf("Pushed %d blocks again", res.Blocks)
+	}
+
+	// Only the new blocks are sent and the previous root is released
+	second := addChunked(t, actx, append(bytes.Repeat([]byte("a"), 1000), 'b'))
+	res, err = Push(ctx, actx, b.Identity, []string{second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Blocks != 2 {
+		t.Errorf("Pushed %d blocks, expected the new leaf and root", res.Blocks)
+	}
+	if !isPinned(t, bctx, second) || isPinned(t, bctx, first) {
+		t.Errorf("Pins after pushing %s in place of %s: %v", second, first, pinsOfType(t, bctx, PinRecursive))
+	}
+	pins, err := ListLabelledPins(ctx, bctx, PinQuery{Owner: pushOwner(a.Identity)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 1 || pins[0].Cid != second || pins[0].Label != pushPinLabel {
+		t.Errorf("Pins of the pusher: %+v", pins)
+	}
+
+	// An offer split into several batches
+	defer func(batch int) { pushOfferBatch = batch }(pushOfferBatch)
+	pushOfferBatch = 2
+	var data []byte
+	for i := 0; i < 10; i++ {
+		data = append(data, bytes.Repeat([]byte{byte(i)}, 100)...)
+	}
+	third := addChunked(t, actx, data)
+	res, err = Push(ctx, actx, b.Identity, []string{second, third})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Blocks != 11 {
+		t.Errorf("Pushed %d blocks in batches, expected 10 leaves and the root", res.Blocks)
+	}
+	if !isPinned(t, bctx, second) || !isPinned(t, bctx, third) {
+		t.Errorf("Pins after pushing in batches: %v", pinsOfType(t, bctx, PinRecursive))
+	}
+}
+
+// Sends offer from a to b by hand along with the blocks b wants, and
+// returns the error b answers with, or that of the stream
+func rawPush(t *testing.T, a *core.IpfsNode, b peer.ID, offer pushOffer) error {
+	s, err := a.PeerHost.NewStream(context.Background(), b, PushProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	r := bufio.NewReader(s)
+	if err := writePushMsg(s, offer); err != nil {
+		return err
+	}
+	var want pushWant
+	if err := readPushMsg(r, &want); err != nil {
+		return err
+	}
+	for _, w := range want.Cids {
+		c, err := cid.Decode(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blk, err := a.Blockstore.Get(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writePushMsg(s, pushBlock{Cid: w, Data: blk.RawData()}); err != nil {
+			return err
+		}
+	}
+	var done pushDone
+	if err := readPushMsg(r, &done); err != nil {
+		return err
+	}
+	if done.Err != "" {
+		return errors.New(done.Err)
+	}
+	return nil
+}
+
+func TestPushLimits(t *testing.T) {
+	ctx := context.Background()
+	a, actx := startNode(t)
+	defer a.Close()
+	b, bctx := startNode(t)
+	defer b.Close()
+	if err := ConnectTo(ctx, actx, dialAddr(t, b)); err != nil {
+		t.Fatal(err)
+	}
+	root := addChunked(t, actx, bytes.Repeat([]byte("a"), 1000))
+
+	for _, opts := range []ServePushOptions{
+		{},
+		{AcceptFrom: []peer.ID{b.Identity}},
+		{AcceptFrom: []peer.ID{a.Identity}, MaxBlocks: 1},
+		{AcceptFrom: []peer.ID{a.Identity}, MaxBytes: 150},
+	} {
+		if err := ServePush(ctx, bctx, opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Push(ctx, actx, b.Identity, []string{root}); err == nil {
+			t.Errorf("%+v: push succeeded", opts)
+		}
+		if isPinned(t, bctx, root) {
+			t.Fatalf("%+v: pushed root is pinned", opts)
+		}
+	}
+
+	// Offers which are not the DAG below the roots
+	if err := ServePush(ctx, bctx, ServePushOptions{AcceptFrom: []peer.ID{a.Identity}}); err != nil {
+		t.Fatal(err)
+	}
+	leaves := func(root string) []string {
+		c, err := cid.Decode(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		links, err := a.DAG.GetLinks(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []string{links[0].Cid.String()}
+	}
+	// b must not fetch the missing leaf from a
+	partial := addChunked(t, actx, bytes.Repeat([]byte("c"), 1000))
+	unrelated := addUnpinned(t, actx, []byte("unrelated"))
+	for _, c := range []struct {
+		name string
+		root string
+		cids []string
+	}{
+		{"missing leaf", partial, []string{partial}},
+		{"unrelated block", root, append([]string{root, unrelated}, leaves(root)...)},
+		{"missing root", root, leaves(root)},
+	} {
+		if err := rawPush(t, a, b.Identity, pushOffer{Roots: []string{c.root}, Cids: c.cids, Last: true}); err == nil {
+			t.Errorf("Push of a %s succeeded", c.name)
+		}
+		if isPinned(t, bctx, c.root) {
+			t.Fatalf("Push of a %s pinned the root", c.name)
+		}
+	}
+	leaf, err := cid.Decode(leaves(partial)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, err := b.Blockstore.Has(leaf); err != nil || has {
+		t.Errorf("Leaf left out of the offer was fetched: %v", err)
+	}
+
+	if _, err := Push(ctx, actx, b.Identity, []string{root}); err != nil {
+		t.Fatal(err)
+	}
+	if !isPinned(t, bctx, root) {
+		t.Error("Pushed root is not pinned")
+	}
+}
+
+func TestConcurrentPushes(t *testing.T) {
+	ctx := context.Background()
+	a, actx := startNode(t)
+	defer a.Close()
+	b, bctx := startNode(t)
+	defer b.Close()
+	if err := ConnectTo(ctx, actx, dialAddr(t, b)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ServePush(ctx, bctx, ServePushOptions{AcceptFrom: []peer.ID{a.Identity}}); err != nil {
+		t.Fatal(err)
+	}
+	first := addUnpinned(t, actx, []byte("first"))
+	second := addUnpinned(t, actx, []byte("second"))
+
+	// The first push stops before sending its block
+	s, err := a.PeerHost.NewStream(ctx, b.Identity, PushProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	r := bufio.NewReader(s)
+	if err := writePushMsg(s, pushOffer{Roots: []string{first}, Cids: []string{first}, Last: true}); err != nil {
+		t.Fatal(err)
+	}
+	var want pushWant
+	if err := readPushMsg(r, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second waits for it
+	done := make(chan error, 1)
+	go func() {
+		_, err := Push(ctx, actx, b.Identity, []string{second})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Push finished during an earlier push of the same peer: %v", err)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	c, err := cid.Decode(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := a.Blockstore.Get(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writePushMsg(s, pushBlock{Cid: first, Data: blk.RawData()}); err != nil {
+		t.Fatal(err)
+	}
+	var pd pushDone
+	if err := readPushMsg(r, &pd); err != nil || pd.Err != "" {
+		t.Fatalf("First push failed: %v %s", err, pd.Err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// The later push replaced the roots of the earlier one
+	if !isPinned(t, bctx, second) || isPinned(t, bctx, first) {
+		t.Errorf("Pins after the pushes: %v", pinsOfType(t, bctx, PinRecursive))
+	}
+}
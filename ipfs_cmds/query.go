@@ -1,35 +1,66 @@
 package ipfs_cmds
 
 import (
+	"context"
+	"errors"
+
 	"github.com/ipfs/go-ipfs/commands"
-	"gx/ipfs/QmPR2JzfKd9poHx9XBhzoFeBBC31ZM3W5iUPKJZWyaoZZm/go-libp2p-routing/notifications"
+
+	notif "gx/ipfs/QmPR2JzfKd9poHx9XBhzoFeBBC31ZM3W5iUPKJZWyaoZZm/go-libp2p-routing/notifications"
+	dht "gx/ipfs/QmT7PnPxYkeKPCG8pAnucfcjrXc15Q7FgvFv7YC24EPrw8/go-libp2p-kad-dht"
+	b58 "gx/ipfs/QmT8rehPR3F6bmwL6zjUN8XpiDBFFpMP2myPdC6ApsWfJf/go-base58"
 	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
 )
 
-func Query(ctx commands.Context, peerID string) ([]peer.ID, error) {
+var errNotDHT = errors.New("Routing service is not a DHT")
+
+// Query returns the peers met while looking for the peers closest to peerID
+func Query(ctx context.Context, cctx commands.Context, peerID string) (_ []peer.ID, err error) {
+	defer wrapErr(&err, "query", peerID)
 	var peers []peer.ID
-	args := []string{"dht", "query", peerID}
-	req, cmd, err := NewRequest(ctx, args)
+	nd, err := cctx.GetNode()
 	if err != nil {
 		return peers, err
 	}
-	res := commands.NewResponse(req)
-	cmd.Run(req, res)
-	resp := res.Output()
-	if res.Error() != nil {
-		log.Error(res.Error())
-		return peers, res.Error()
+	if !nd.OnlineMode() {
+		return peers, ErrOffline
+	}
+	// The DHT constructed by ipfs_core.DHTOption
+	d, ok := nd.Routing.(*dht.IpfsDHT)
+	if !ok {
+		return peers, errNotDHT
+	}
+
+	events := make(chan *notif.QueryEvent)
+	qctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	qctx = notif.RegisterForQueryEvents(qctx, events)
+
+	closestPeers, err := d.GetClosestPeers(qctx, string(b58.Decode(peerID)))
+	if err != nil {
+		log.Error(err)
+		return peers, err
 	}
-	peerChan := resp.(<-chan interface{})
+	go func() {
+		defer close(events)
+		for p := range closestPeers {
+			notif.PublishQueryEvent(qctx, &notif.QueryEvent{
+				ID:   p,
+				Type: notif.FinalPeer,
+			})
+		}
+	}()
+
 	peerMap := make(map[string]peer.ID)
-	for p := range peerChan {
-		peerMap[p.(*notifications.QueryEvent).ID.Pretty()] = p.(*notifications.QueryEvent).ID
-		if len(p.(*notifications.QueryEvent).Responses) > 0 {
-			for _, r := range p.(*notifications.QueryEvent).Responses {
-				peerMap[r.ID.Pretty()] = r.ID
-			}
+	for e := range events {
+		peerMap[e.ID.Pretty()] = e.ID
+		for _, r := range e.Responses {
+			peerMap[r.ID.Pretty()] = r.ID
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	for _, v := range peerMap {
 		peers = append(peers, v)
 	}
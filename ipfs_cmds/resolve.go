@@ -1,25 +1,64 @@
 package ipfs_cmds
 
 import (
+	"context"
+	"strings"
+	"sync"
+
 	"github.com/ipfs/go-ipfs/commands"
-	coreCmds "github.com/ipfs/go-ipfs/core/commands"
-	"time"
+	"github.com/ipfs/go-ipfs/core"
 )
 
-// Publish a signed IPNS record to our Peer ID
-func Resolve(ctx commands.Context, hash string, timeout time.Duration) (string, error) {
-	args := []string{"name", "resolve", hash}
-	req, cmd, err := NewRequestWithTimeout(ctx, args, timeout)
+// Serializes setting up the routing of offline nodes
+var offlineRoutingLock sync.Mutex
+
+// Returns the node of cctx for name operations. An offline node built
+// without routing gets an offline router the first time, nodes are not
+// changed afterwards.
+func nameNode(cctx commands.Context) (*core.IpfsNode, error) {
+	nd, err := cctx.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	if nd.OnlineMode() {
+		return nd, nil
+	}
+	offlineRoutingLock.Lock()
+	defer offlineRoutingLock.Unlock()
+	if nd.Routing == nil {
+		if err := nd.SetupOfflineRouting(); err != nil {
+			return nil, err
+		}
+	}
+	return nd, nil
+}
+
+// Resolve an IPNS name to the hash it points to
+func Resolve(ctx context.Context, cctx commands.Context, hash string) (_ string, err error) {
+	defer wrapErr(&err, "resolve", hash)
+	nd, err := nameNode(cctx)
 	if err != nil {
 		return "", err
 	}
-	res := commands.NewResponse(req)
-	cmd.Run(req, res)
-	resp := res.Output()
-	if res.Error() != nil {
-		log.Error(res.Error())
-		return "", res.Error()
+
+	name := hash
+	if !strings.HasPrefix(name, "/ipns/") {
+		name = "/ipns/" + name
+	}
+	p, err := nd.Namesys.ResolveN(ctx, name, 1)
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+	return p.Segments()[1], nil
+}
+
+// Resolve the IPNS name of the key called key
+func ResolveKey(ctx context.Context, cctx commands.Context, key string) (_ string, err error) {
+	defer wrapErr(&err, "resolve key", key)
+	name, err := KeyName(ctx, cctx, key)
+	if err != nil {
+		return "", err
 	}
-	returnedVal := resp.(*coreCmds.ResolvedPath)
-	return returnedVal.Path.Segments()[1], nil
+	return Resolve(ctx, cctx, "/ipns/"+name)
 }
@@ -0,0 +1,43 @@
+package ipfs_cmds
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestPublishResolveConcurrent(t *testing.T) {
+	ctx := context.Background()
+	cctx, err := MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := cctx.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routing, namesys := nd.Routing, nd.Namesys
+	hash := addUnpinned(t, cctx, []byte("published"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Publish(ctx, cctx, hash); err != nil {
+				t.Error(err)
+			}
+			if _, err := Resolve(ctx, cctx, nd.Identity.Pretty()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if nd.Routing != routing || nd.Namesys != namesys {
+		t.Error("The routing of the node was replaced")
+	}
+	if got, err := Resolve(ctx, cctx, nd.Identity.Pretty()); err != nil || got != hash {
+		t.Errorf("Resolved %s (%v), expected %s", got, err, hash)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	//"flag"
@@ -33,11 +34,11 @@ func main() {
 	}
 	//=========================================== Init ===========================================
 	// Set repo path
-	repoPath, err := ipfs_core.Init()
+	node, err := ipfs_core.Init()
 	if err != nil {
 		os.Exit(1)
 	}
-	fmt.Printf("ipfs_demo repo initialized at %s\n", repoPath)
+	fmt.Printf("ipfs_demo repo initialized at %s\n", node.RepoPath)
 
 	//ctx := commands.Context{}
 	//ctx.Online = true
@@ -56,15 +57,27 @@ func main() {
 	// log_test.Info(logmsg)
 
 	//=========================================== Start ===========================================
-	err = ipfs_core.Start(repoPath)
+	err = node.Start()
 	if err != nil {
 		log_test.Infof("%s\n", err.Error())
 		os.Exit(1)
 	}
 	fmt.Printf("Daemon is ready\n")
+	node.StopOnSignal(time.Second * 30)
+
+	readyCtx, readyCancel := context.WithTimeout(context.Background(), time.Second*60)
+	status, err := node.WaitReady(readyCtx, ipfs_core.ReadyCriteria{MinPeers: 1, BootstrapPeer: true})
+	readyCancel()
+	if err != nil {
+		log_test.Infof("Node not ready: %s (%d peers)\n", err.Error(), status.Peers)
+	} else {
+		log_test.Infof("Node ready: %d peers, reachability %s\n", status.Peers, status.Reachability)
+	}
+
+	ctx := context.Background()
 
 	// //=========================================== Set ipfs log level ===========================================
-	// logmsg, logerr := ipfs_cmds.Log(ipfs_core.Node.Context, "all", "debug")
+	// logmsg, logerr := ipfs_cmds.Log(node.Context, "all", "debug")
 	// if logerr != nil {
 	// 	log_test.Error(logerr.Error())
 	// 	os.Exit(1)
@@ -75,7 +88,7 @@ func main() {
 		//=========================================== Add ===========================================
 		//README.md on linux:   zb2rhneqJaf4y9vQpb9o1yqyejARwiR9PDuz8bXjRTAE5iLT9
 		//README.md on windows: zb2rhjwNxFKtD3Qg4nV3Qf4CH77bvEn7ndzM4ysXCwxvpLXeo
-		hash, err := ipfs_cmds.AddFile(ipfs_core.Node.Context, filepath.Join("./", "README.md"))
+		hash, err := ipfs_cmds.AddFile(ctx, node.Context, filepath.Join("./", "README.md"))
 		if err != nil {
 			log_test.Info(err.Error())
 			os.Exit(1)
@@ -88,7 +101,7 @@ func main() {
 			log_test.Info("Ipfs add file successfully: ", hash)
 		}
 		//test.bin: zdj7WdnQBd3Yf4KPuUTZ9mkAQ6Rfd87H4h2f7d3KxzgW4kJ9U
-		hash, err = ipfs_cmds.AddFile(ipfs_core.Node.Context, filepath.Join("./resource", "test.bin"))
+		hash, err = ipfs_cmds.AddFile(ctx, node.Context, filepath.Join("./resource", "test.bin"))
 		if err != nil {
 			log_test.Info(err.Error())
 			os.Exit(1)
@@ -104,7 +117,7 @@ func main() {
 		////peer := "/ip4/138.197.232.22/tcp/4001/ipfs/QmZjmQH4e7opwmeFc23vUZ4nwuPw1oJgFKSpJoAJgrpQiy"
 		//peer := "/ip4/97.64.43.18/tcp/4001/ipfs/QmPUrqtsYZzpebQ4sYHiQqjtTGCEGUVu194jhHuVpBnGb3"
 		//for i := 0; i < 5; i++ {
-		//	peers, err := ipfs.ConnectTo(ipfs_core.Node.Context, peer)
+		//	peers, err := ipfs.ConnectTo(node.Context, peer)
 		//	if err != nil {
 		//		log_test.Info(err.Error())
 		//		//os.Exit(1)
@@ -127,9 +140,14 @@ func main() {
 	}
 
 	for i := 0; i < 3; i++ {
-		dataText, err := ipfs_cmds.Cat(ipfs_core.Node.Context, file_hash, time.Second*120)
+		catCtx, catCancel := context.WithTimeout(ctx, time.Second*120)
+		dataText, err := ipfs_cmds.Cat(catCtx, node.Context, file_hash)
+		catCancel()
 		if err != nil {
 			log_test.Info(err.Error())
+			if !ipfs_cmds.IsTemporary(err) {
+				break
+			}
 			<-time.After(1 * time.Second)
 			continue
 		} else {
@@ -141,25 +159,15 @@ func main() {
 	//=========================================== Swarm peers ===========================================
 	go func() {
 		for {
-			pbool := make(chan []string)
-			go func() {
-				peers, err := ipfs_cmds.ConnectedPeers(ipfs_core.Node.Context)
-				if err != nil {
-					errInfo := make([]string, 1)
-					errInfo = append(errInfo, err.Error())
-					pbool <- errInfo
-					log_test.Info(err.Error())
-				}
-				pbool <- peers
-			}()
-			peers := <-pbool
-			if len(peers) == 0 {
+			peers, err := ipfs_cmds.ConnectedPeers(ctx, node.Context)
+			if err != nil {
+				log_test.Info(err.Error())
+			} else if len(peers) == 0 {
 				log_test.Infof("No peers in swarm")
 			} else {
 				for i, peer := range peers {
-					log_test.Infof("peer #%d: %s\n", i, peer)
+					log_test.Infof("peer #%d: %s %s %s %s %s\n", i, peer.ID, peer.Addr, peer.Direction, peer.Latency, peer.AgentVersion)
 				}
-				//break
 			}
 			<-time.After(1 * time.Second)
 		}
@@ -176,9 +184,12 @@ func main() {
 	// pin add
 	for _, hash := range fhash {
 		for j := 0; j < 3; j++ {
-			err := ipfs_cmds.Pin(ipfs_core.Node.Context, hash)
+			err := ipfs_cmds.Pin(ctx, node.Context, hash)
 			if err != nil {
 				log_test.Info(err.Error())
+				if !ipfs_cmds.IsTemporary(err) {
+					break
+				}
 				<-time.After(1 * time.Second)
 			} else {
 				log_test.Infof("Pin %s Ok!", hash)
@@ -187,20 +198,23 @@ func main() {
 		}
 	}
 	// pin ls
-	objs1, err := ipfs_cmds.PinLs(ipfs_core.Node.Context)
+	objs1, err := ipfs_cmds.PinLs(ctx, node.Context, ipfs_cmds.PinAll)
 	if err != nil {
 		log_test.Info(err.Error())
 	} else {
 		for i, obj := range objs1 {
-			log_test.Infof("obj #%d: %s\n", i, obj)
+			log_test.Infof("obj #%d: %s %s\n", i, obj.Cid, obj.Type)
 		}
 	}
 	// unpin
 	for _, hash := range fhash {
 		for j := 0; j < 3; j++ {
-			err := ipfs_cmds.UnPinDir(ipfs_core.Node.Context, hash)
+			err := ipfs_cmds.UnPinDir(ctx, node.Context, hash)
 			if err != nil {
 				log_test.Info(err.Error())
+				if !ipfs_cmds.IsTemporary(err) {
+					break
+				}
 				<-time.After(1 * time.Second)
 			} else {
 				log_test.Infof("UnPin %s Ok!", hash)
@@ -209,12 +223,12 @@ func main() {
 		}
 	}
 	// pin ls
-	objs2, err := ipfs_cmds.PinLs(ipfs_core.Node.Context)
+	objs2, err := ipfs_cmds.PinLs(ctx, node.Context, ipfs_cmds.PinAll)
 	if err != nil {
 		log_test.Info(err.Error())
 	} else {
 		for i, obj := range objs2 {
-			log_test.Infof("obj #%d: %s\n", i, obj)
+			log_test.Infof("obj #%d: %s %s\n", i, obj.Cid, obj.Type)
 		}
 	}
 
@@ -231,12 +245,17 @@ func main() {
 			fnamebuf.WriteString("_")
 			fnamebuf.WriteString(strconv.Itoa(j))
 			ofpath := filepath.Join(home, fnamebuf.String())
-			d, err := ipfs_cmds.Get(ipfs_core.Node.Context, hash, ofpath, time.Second*120)
+			getCtx, getCancel := context.WithTimeout(ctx, time.Second*120)
+			res, err := ipfs_cmds.Get(getCtx, node.Context, hash, ofpath, ipfs_cmds.GetOptions{})
+			getCancel()
 			if err != nil {
 				log_test.Info(err.Error())
+				if !ipfs_cmds.IsTemporary(err) {
+					break
+				}
 				<-time.After(1 * time.Second)
 			} else {
-				log_test.Infof("Get %s Ok!", d)
+				log_test.Infof("Get %s Ok! %d files, %d bytes in %s", res.Cid, res.Files, res.Bytes, res.Duration)
 				break
 			}
 		}
@@ -245,5 +264,9 @@ func main() {
 	//=========================================== End ===========================================
 	fmt.Print("Press 'Enter' to continue ...")
 	bufio.NewReader(os.Stdin).ReadBytes('\n')
-	os.Exit(1)
+	sctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	if err := node.Stop(sctx); err != nil {
+		log_test.Error(err.Error())
+	}
 }
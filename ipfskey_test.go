@@ -2,166 +2,96 @@ package main
 
 import (
 	"bytes"
-	"errors"
-	"fmt"
-	"os"
 	"testing"
 
-	"crypto/rand"
-	"encoding/pem"
+	"github.com/jason860306/ipfs_demo/ipfs_cmds"
 
 	libp2p "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
 )
 
-func exportRsaPrivateKeyAsPemStr(privkey libp2p.PrivKey) string {
-	rsaSK, ok := privkey.(*libp2p.RsaPrivateKey)
-	if !ok {
-		return ""
-	}
-	privkey_bytes := libp2p.MarshalRsaPrivateKey(rsaSK)
-	privkey_pem := pem.EncodeToMemory(
-		&pem.Block{
-			Type:  "RSA PRIVATE KEY",
-			Bytes: privkey_bytes,
-		},
-	)
-	return string(privkey_pem)
+var keyFormats = []ipfs_cmds.KeyFormat{
+	ipfs_cmds.KeyFormatProtobuf,
+	ipfs_cmds.KeyFormatDER,
+	ipfs_cmds.KeyFormatPEM,
 }
 
-func exportRsaPrivateKey(privkey libp2p.PrivKey) []byte {
-	rsaSK, ok := privkey.(*libp2p.RsaPrivateKey)
-	if !ok {
-		return nil
+func TestIpfsKey(t *testing.T) {
+	for _, typ := range []int{libp2p.RSA, libp2p.Ed25519, libp2p.Secp256k1} {
+		sk, pk, err := libp2p.GenerateKeyPair(typ, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := ipfs_cmds.KeyTypeName(typ)
+
+		for _, format := range keyFormats {
+			// Export the keys, import them again and check they did not change
+			skData, err := ipfs_cmds.ExportPrivateKey(sk, format)
+			if err != nil {
+				t.Fatalf("%s/%s: %s", name, format, err)
+			}
+			skParsed, err := ipfs_cmds.ImportPrivateKey(skData, format)
+			if err != nil {
+				t.Fatalf("%s/%s: %s", name, format, err)
+			}
+			if !sk.Equals(skParsed) {
+				t.Errorf("%s/%s: export and import did not result in the same private key", name, format)
+			}
+			skParsedData, err := ipfs_cmds.ExportPrivateKey(skParsed, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(skData, skParsedData) {
+				t.Errorf("%s/%s: private key exports differ", name, format)
+			}
+
+			pkData, err := ipfs_cmds.ExportPublicKey(pk, format)
+			if err != nil {
+				t.Fatalf("%s/%s: %s", name, format, err)
+			}
+			pkParsed, err := ipfs_cmds.ImportPublicKey(pkData, format)
+			if err != nil {
+				t.Fatalf("%s/%s: %s", name, format, err)
+			}
+			if !pk.Equals(pkParsed) {
+				t.Errorf("%s/%s: export and import did not result in the same public key", name, format)
+			}
+		}
 	}
-	return libp2p.MarshalRsaPrivateKey(rsaSK)
 }
 
-func parseRsaPrivateKeyFromPemStr(privPEM string) (libp2p.PrivKey, error) {
-
-	block, _ := pem.Decode([]byte(privPEM))
-	if block == nil {
-		return nil, errors.New("failed to parse PEM block containing the key")
-	}
-
-	privkey, err := libp2p.UnmarshalRsaPrivateKey(block.Bytes)
+func TestImportIdentityKey(t *testing.T) {
+	ident, err := ipfs_cmds.IdentityConfig(libp2p.Ed25519, 0)
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
 	}
-
-	return privkey, nil
-}
-
-func parseRsaPrivateKey(skbytes []byte) (libp2p.PrivKey, error) {
-	privkey, err := libp2p.UnmarshalRsaPrivateKey(skbytes)
-	if err != nil {
-		return nil, err
+	for _, format := range keyFormats {
+		data, err := ipfs_cmds.ExportIdentityKey(ident, format)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		imported, err := ipfs_cmds.ImportIdentityKey(data, format)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if imported != ident {
+			t.Errorf("%s: imported identity differs from the exported one", format)
+		}
 	}
-	return privkey, nil
 }
 
-func exportRsaPublicKeyAsPemStr(pubkey libp2p.PubKey) (string, error) {
-	rsaPK, ok := pubkey.(*libp2p.RsaPublicKey)
-	if !ok {
-		os.Exit(1)
-	}
-	pubkey_bytes, err := libp2p.MarshalRsaPublicKey(rsaPK)
+func TestImportRejectsMismatchedFormat(t *testing.T) {
+	sk, _, err := libp2p.GenerateKeyPair(libp2p.Ed25519, 0)
 	if err != nil {
-		return "", err
+		t.Fatal(err)
 	}
-	pubkey_pem := pem.EncodeToMemory(
-		&pem.Block{
-			Type:  "RSA PUBLIC KEY",
-			Bytes: pubkey_bytes,
-		},
-	)
-
-	return string(pubkey_pem), nil
-}
-
-func exportRsaPublicKey(pubkey libp2p.PubKey) ([]byte, error) {
-	rsaPK, ok := pubkey.(*libp2p.RsaPublicKey)
-	if !ok {
-		return nil, errors.New("fetch rsapubkey failed")
-	}
-	pubkey_bytes, err := libp2p.MarshalRsaPublicKey(rsaPK)
+	data, err := ipfs_cmds.ExportPrivateKey(sk, ipfs_cmds.KeyFormatPEM)
 	if err != nil {
-		return nil, err
-	}
-	return pubkey_bytes, nil
-}
-
-func parseRsaPublicKeyFromPemStr(pubPEM string) (libp2p.PubKey, error) {
-	block, _ := pem.Decode([]byte(pubPEM))
-	if block == nil {
-		return nil, errors.New("failed to parse PEM block containing the key")
+		t.Fatal(err)
 	}
-
-	pub, err := libp2p.UnmarshalRsaPublicKey(block.Bytes)
-	if err != nil {
-		return nil, err
+	if _, err := ipfs_cmds.ImportPrivateKey(data, ipfs_cmds.KeyFormatProtobuf); err == nil {
+		t.Error("Should have refused a PEM key as protobuf")
 	}
-	return pub, nil
-}
-
-func parseRsaPublicKey(pkbytes []byte) (libp2p.PubKey, error) {
-	pub, err := libp2p.UnmarshalRsaPublicKey(pkbytes)
-	if err != nil {
-		return nil, err
-	}
-	return pub, nil
-}
-
-func TestIpfsKey(t *testing.T) {
-	const nBitsForKeypair = 4096
-	skMarshal, _, err := libp2p.GenerateKeyPairWithReader(libp2p.RSA, nBitsForKeypair, rand.Reader /*reader*/)
-	if err != nil {
-		os.Exit(1)
-	}
-
-	//=========================================== via pem ===========================================
-	// Export the keys to pem string
-	priv_pem := exportRsaPrivateKeyAsPemStr(skMarshal)
-	pub_pem, _ := exportRsaPublicKeyAsPemStr(skMarshal.GetPublic())
-
-	// Import the keys from pem string
-	priv_parsed, _ := parseRsaPrivateKeyFromPemStr(priv_pem)
-	pub_parsed, _ := parseRsaPublicKeyFromPemStr(pub_pem)
-
-	// Export the newly imported keys
-	priv_parsed_pem := exportRsaPrivateKeyAsPemStr(priv_parsed)
-	pub_parsed_pem, _ := exportRsaPublicKeyAsPemStr(pub_parsed)
-
-	fmt.Println(priv_parsed_pem)
-	fmt.Println(pub_parsed_pem)
-
-	// Check that the exported/imported keys match the original keys
-	if priv_pem != priv_parsed_pem && pub_pem != pub_parsed_pem {
-		fmt.Println("Failure: Export and Import did not result in same Keys")
-	} else {
-		fmt.Println("Success")
-	}
-
-	//=========================================== via rawdata ===========================================
-	// Export the keys to pem string
-	priv_pem1 := exportRsaPrivateKey(skMarshal)
-	pub_pem1, _ := exportRsaPublicKey(skMarshal.GetPublic())
-
-	// Import the keys from pem string
-	priv_parsed1, _ := parseRsaPrivateKey(priv_pem1)
-	pub_parsed1, _ := parseRsaPublicKey(pub_pem1)
-
-	// Export the newly imported keys
-	priv_parsed_pem1 := exportRsaPrivateKey(priv_parsed1)
-	pub_parsed_pem1, _ := exportRsaPublicKey(pub_parsed1)
-
-	fmt.Printf("%v\n", priv_parsed_pem1)
-	fmt.Printf("%v\n", pub_parsed_pem1)
-
-	// Check that the exported/imported keys match the original keys
-	if !bytes.Equal(priv_pem1, priv_parsed_pem1) ||
-		!bytes.Equal(pub_pem1, pub_parsed_pem1) {
-		fmt.Println("Failure: Export and Import did not result in same Keys")
-	} else {
-		fmt.Println("Success")
+	if _, err := ipfs_cmds.ImportPublicKey(data, ipfs_cmds.KeyFormatPEM); err == nil {
+		t.Error("Should have refused a private key as a public key")
 	}
 }